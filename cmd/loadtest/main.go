@@ -0,0 +1,404 @@
+// Command loadtest connects many simulated players to a server, has them walk, fly, build and chat,
+// and reports how quickly the server answers them.
+//
+// Start a server on a loopback port first, for example "server loadtest 1 5599", then run
+// "loadtest -port 5599 -players 50". Players play in the game mode the server gives them: in survival
+// they mine cells and build with what they collected, and with "gamemode=creative" in the server config
+// they break and build freely.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/rpc"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+	"github.com/jeffbaumes/buildorb/pkg/headless"
+)
+
+// tickInterval is how often each simulated player moves
+const tickInterval = 50 * time.Millisecond
+
+var (
+	host      = flag.String("host", "localhost", "server host")
+	port      = flag.Int("port", 5555, "server port")
+	players   = flag.Int("players", 10, "number of simulated players")
+	prefix    = flag.String("name", "bot", "name prefix of the simulated players")
+	duration  = flag.Duration("duration", time.Minute, "how long to run, including ramp-up")
+	ramp      = flag.Duration("ramp", 100*time.Millisecond, "time between players joining")
+	walk      = flag.Float64("walk", 0.8, "fraction of players who move around")
+	fly       = flag.Float64("fly", 0.2, "fraction of moving players who fly")
+	turns     = flag.Float64("turns", 0.5, "turns per second for each moving player")
+	edits     = flag.Float64("edits", 0.5, "cell edits per second for each player")
+	chats     = flag.Float64("chats", 0.05, "chat messages per second for each player")
+	seed      = flag.Int64("seed", 1, "random seed")
+	reconnect = flag.Duration("reconnect", headless.DefaultReconnectTimeout, "how long players keep trying to reconnect, 0 for not at all")
+	interval  = flag.Duration("progress", 5*time.Second, "time between progress lines, 0 for none")
+)
+
+// report gathers latencies and counts from every simulated player
+type report struct {
+	mutex     sync.Mutex
+	latencies map[string][]time.Duration
+	counts    map[string]int
+}
+
+var stats = &report{latencies: make(map[string][]time.Duration), counts: make(map[string]int)}
+
+// observe records how long something took
+func (r *report) observe(kind string, elapsed time.Duration) {
+	r.mutex.Lock()
+	r.latencies[kind] = append(r.latencies[kind], elapsed)
+	r.mutex.Unlock()
+}
+
+// add adds to a count
+func (r *report) add(kind string, n int) {
+	r.mutex.Lock()
+	r.counts[kind] += n
+	r.mutex.Unlock()
+}
+
+// get returns a count
+func (r *report) get(kind string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.counts[kind]
+}
+
+// percentile returns the latency below which a fraction q of sorted latencies fall
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*q)]
+}
+
+// write writes the final report
+func (r *report) write(elapsed time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	seconds := elapsed.Seconds()
+	fmt.Printf("Players: %v joined, %v failed to join, %v reconnected, %v disconnected\n",
+		r.counts["joined"], r.counts["join failures"], r.counts["reconnects"], r.counts["disconnects"])
+	fmt.Printf("Throughput over %v: %.1f edits/s, %.1f chunks/s, %.1f cell changes/s and %.1f chat messages/s received\n",
+		elapsed.Round(time.Second), float64(r.counts["edits"])/seconds, float64(r.counts["chunks"])/seconds,
+		float64(r.counts["cell changes"])/seconds, float64(r.counts["chat messages"])/seconds)
+	refusals := []string{}
+	for kind := range r.counts {
+		if strings.HasPrefix(kind, "refused: ") {
+			refusals = append(refusals, kind)
+		}
+	}
+	sort.Strings(refusals)
+	for _, kind := range refusals {
+		fmt.Printf("Edits %v (%v)\n", kind, r.counts[kind])
+	}
+	fmt.Println("Edit latency runs until the change comes back in a chunk delta, edit reply until the server answers.")
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "latency\tcount\tp50\tp90\tp99\tmax\t")
+	for _, kind := range []string{"join", "chunk batch", "edit reply", "edit", "chat echo"} {
+		l := r.latencies[kind]
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t\n", kind, len(l),
+			round(percentile(l, 0.5)), round(percentile(l, 0.9)), round(percentile(l, 0.99)), round(percentile(l, 1)))
+	}
+	w.Flush()
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
+
+// bot is one simulated player
+type bot struct {
+	name   string
+	client *headless.Client
+	rand   *rand.Rand
+	mutex  sync.Mutex
+	chats  map[string]time.Time
+	edits  map[common.PlanetCellIndex]time.Time
+	moving bool
+	flying bool
+
+	// The cell a survival player is mining, when they started and how long it takes
+	mining       bool
+	miningCell   common.PlanetCellIndex
+	miningStart  time.Time
+	miningLength time.Duration
+}
+
+func main() {
+	flag.Parse()
+	common.ChunksReceived = func(chunks int, elapsed time.Duration) {
+		stats.observe("chunk batch", elapsed)
+		stats.add("chunks", chunks)
+	}
+
+	start := time.Now()
+	end := start.Add(*duration)
+	if *interval > 0 {
+		go progress(start)
+	}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < *players && time.Now().Before(end); i++ {
+		b := &bot{
+			name:  fmt.Sprintf("%v%v", *prefix, i),
+			rand:  rand.New(rand.NewSource(*seed + int64(i))),
+			chats: make(map[string]time.Time),
+			edits: make(map[common.PlanetCellIndex]time.Time),
+		}
+		b.moving = b.rand.Float64() < *walk
+		b.flying = b.moving && b.rand.Float64() < *fly
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.run(end)
+		}()
+		time.Sleep(*ramp)
+	}
+	wg.Wait()
+	stats.write(time.Since(start))
+}
+
+// progress prints the counts so far every interval
+func progress(start time.Time) {
+	for range time.Tick(*interval) {
+		log.Printf("%v: %v connected, %v edits, %v chunks, %v disconnects",
+			time.Since(start).Round(time.Second), stats.get("connected"), stats.get("edits"),
+			stats.get("chunks"), stats.get("disconnects"))
+	}
+}
+
+// run joins the server and plays until the end time or until the server disconnects the player
+func (b *bot) run(end time.Time) {
+	joinStart := time.Now()
+	c, err := headless.Dial(*host, *port, b.name, "loadtest-"+b.name, headless.Handlers{
+		Chat:        b.receiveChat,
+		ChunkDelta:  b.receiveDelta,
+		Reconnected: func() { stats.add("reconnects", 1) },
+	})
+	if err != nil {
+		log.Printf("%v could not join: %v", b.name, err)
+		stats.add("join failures", 1)
+		return
+	}
+	b.client = c
+	c.SetReconnectTimeout(*reconnect)
+	c.Spawn(0)
+	stats.observe("join", time.Since(joinStart))
+	stats.add("joined", 1)
+	stats.add("connected", 1)
+	defer stats.add("connected", -1)
+
+	if b.flying {
+		c.Player.MovementMode = common.Flying
+	}
+	b.walk()
+
+	now := time.Now()
+	nextTurn, nextEdit, nextChat := b.after(now, *turns), b.after(now, *edits), b.after(now, *chats)
+	last := now
+	tick := time.NewTicker(tickInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-c.Closed():
+			log.Printf("%v was disconnected: %v", b.name, c.DisconnectReason())
+			stats.add("disconnects", 1)
+			return
+		case now = <-tick.C:
+		}
+		if now.After(end) {
+			c.Close()
+			return
+		}
+		c.Update(float32(now.Sub(last).Seconds()))
+		last = now
+		if b.mining {
+			if now.Sub(b.miningStart) >= b.miningLength {
+				b.breakMined(now)
+			}
+		} else {
+			if b.moving && now.After(nextTurn) {
+				nextTurn = b.after(now, *turns)
+				b.turn()
+			}
+			if now.After(nextEdit) {
+				nextEdit = b.after(now, *edits)
+				b.edit(now)
+			}
+		}
+		if now.After(nextChat) {
+			nextChat = b.after(now, *chats)
+			b.chat()
+		}
+	}
+}
+
+// after returns when to next do something done at a rate per second, at random like arrivals in a queue
+func (b *bot) after(now time.Time, rate float64) time.Time {
+	if rate <= 0 {
+		return now.Add(100 * 365 * 24 * time.Hour)
+	}
+	return now.Add(time.Duration(b.rand.ExpFloat64() / rate * float64(time.Second)))
+}
+
+// turn turns up to 90 degrees either way, and has flying players pick whether to climb, sink or hold
+func (b *bot) turn() {
+	player := b.client.Player
+	player.Swivel(b.rand.Float64()*1800-900, 0)
+	if b.flying {
+		player.UpVel, player.DownVel = 0, 0
+		switch b.rand.Intn(3) {
+		case 0:
+			player.UpVel = player.WalkVel
+		case 1:
+			player.DownVel = player.WalkVel
+		}
+	}
+}
+
+// walk sets moving players walking or flying again
+func (b *bot) walk() {
+	if b.moving {
+		// Walkers keep jumping so they climb over the terrain instead of stopping at the first step
+		player := b.client.Player
+		player.ForwardVel = player.WalkVel
+		player.HoldingJump = true
+	}
+}
+
+// stand stops the player moving, so a cell they are mining stays in reach
+func (b *bot) stand() {
+	player := b.client.Player
+	player.ForwardVel, player.UpVel, player.DownVel = 0, 0, 0
+	player.HoldingJump = false
+}
+
+// edit breaks the ground in front of the player if it is solid or fills it if it is not.
+// Survival players start mining the cell and build with the items they have collected.
+func (b *bot) edit(now time.Time) {
+	player := b.client.Player
+	planet := player.Planet
+	up := player.Location().Normalize()
+	pos := player.Location().Add(player.LookDir().Mul(3)).Sub(up.Mul(2.5))
+	ind := common.PlanetCellIndex{Planet: planet.ID, CellIndex: planet.CartesianToCellIndex(pos)}
+	cell := planet.CellIndexToCell(ind.CellIndex)
+	if cell == nil {
+		return
+	}
+	// Deltas from the server change cells as they arrive
+	planet.DeltaMutex.Lock()
+	material := cell.Material
+	planet.DeltaMutex.Unlock()
+	creative := player.GameMode == common.Creative
+	switch {
+	case material == common.Air:
+		if material := b.buildMaterial(); material != common.Air {
+			b.send(ind, b.client.SetCellMaterial(ind, material, 0))
+		}
+	case creative:
+		contents := []common.Slot{}
+		b.send(ind, b.client.BreakCell(ind, 0, &contents))
+	default:
+		b.client.StartMining(ind)
+		b.mining, b.miningCell, b.miningStart = true, ind, now
+		b.miningLength = time.Duration(float64(common.MaterialHardness[material]) * float64(time.Second))
+		b.stand()
+	}
+}
+
+// breakMined breaks the cell the player has finished mining and sets them moving again
+func (b *bot) breakMined(now time.Time) {
+	contents := []common.Slot{}
+	b.send(b.miningCell, b.client.BreakCell(b.miningCell, float32(now.Sub(b.miningStart).Seconds()), &contents))
+	b.mining = false
+	b.walk()
+}
+
+// buildMaterial returns what the player builds with: stone in creative mode, otherwise
+// the first material they have, or air if they have nothing
+func (b *bot) buildMaterial() int {
+	player := b.client.Player
+	if player.GameMode == common.Creative {
+		return common.Stone
+	}
+	for _, slot := range append(player.Hotbar[:], player.Inventory[:]...) {
+		if slot.Amount > 0 && slot.Material != common.Air {
+			return slot.Material
+		}
+	}
+	return common.Air
+}
+
+// send times an edit, both until the server answers and until the change comes back in a chunk delta
+func (b *bot) send(ind common.PlanetCellIndex, call *rpc.Call) {
+	start := time.Now()
+	b.mutex.Lock()
+	b.edits[ind] = start
+	b.mutex.Unlock()
+	go func() {
+		err := headless.Wait(call)
+		stats.observe("edit reply", time.Since(start))
+		stats.add("edits", 1)
+		if err != nil {
+			stats.add("refused: "+err.Error(), 1)
+			// A refused edit changes nothing, so no delta will finish timing it
+			b.mutex.Lock()
+			if b.edits[ind].Equal(start) {
+				delete(b.edits, ind)
+			}
+			b.mutex.Unlock()
+		}
+	}()
+}
+
+// receiveDelta counts changed cells and finishes timing the player's own edits
+func (b *bot) receiveDelta(planet int, applied []common.CellChange) {
+	stats.add("cell changes", len(applied))
+	now := time.Now()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, change := range applied {
+		ind := common.PlanetCellIndex{Planet: planet, CellIndex: change.Index}
+		if start, ok := b.edits[ind]; ok {
+			stats.observe("edit", now.Sub(start))
+			delete(b.edits, ind)
+		}
+	}
+}
+
+// chat sends a chat message, remembering when so the time until it comes back can be measured
+func (b *bot) chat() {
+	text := fmt.Sprintf("%v says %v", b.name, b.rand.Int63())
+	b.mutex.Lock()
+	b.chats[text] = time.Now()
+	b.mutex.Unlock()
+	b.client.Chat(text)
+}
+
+// receiveChat counts chat messages and times the player's own messages coming back
+func (b *bot) receiveChat(msg common.ChatMessage) {
+	stats.add("chat messages", 1)
+	if msg.From != b.name {
+		return
+	}
+	b.mutex.Lock()
+	sent, ok := b.chats[msg.Text]
+	delete(b.chats, msg.Text)
+	b.mutex.Unlock()
+	if ok {
+		stats.observe("chat echo", time.Since(sent))
+	}
+}
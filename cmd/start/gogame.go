@@ -3,10 +3,10 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"log"
 	"os"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/jeffbaumes/buildorb/pkg/client"
 	"github.com/jeffbaumes/buildorb/pkg/server"
@@ -40,7 +40,7 @@ func main() {
 
 			worldstr, _ := reader.ReadString('\n')
 			if strings.TrimSpace(worldstr) != "" {
-				sworld = worldstr
+				sworld = strings.TrimSpace(worldstr)
 			}
 			reader = bufio.NewReader(os.Stdin)
 			fmt.Print("Enter seed for server (leave blank for 1): ")
@@ -51,7 +51,9 @@ func main() {
 					panic(e)
 				}
 			}
-			reader = bufio.NewReader(os.Stdin)
+		}
+		if play == "server" {
+			reader := bufio.NewReader(os.Stdin)
 			fmt.Print("Enter port for server (leave blank for 5555): ")
 			portstr, _ := reader.ReadString('\n')
 			if strings.TrimSpace(portstr) != "" {
@@ -86,9 +88,11 @@ func main() {
 
 			namestr, _ := reader.ReadString('\n')
 			if strings.TrimSpace(namestr) != "" {
-				name = namestr
+				name = strings.TrimSpace(namestr)
 			}
-			reader = bufio.NewReader(os.Stdin)
+		}
+		if play == "client" {
+			reader := bufio.NewReader(os.Stdin)
 			fmt.Print("Enter host for client (leave blank for 'localhost'): ")
 			hoststr, _ := reader.ReadString('\n')
 			if strings.TrimSpace(hoststr) != "" {
@@ -105,15 +109,18 @@ func main() {
 			}
 		}
 	}
-	if play == "server" || play == "all" {
-		if play == "all" {
-			go server.Start(sworld, sseed, sport)
-		} else {
-			server.Start(sworld, sseed, sport)
-		}
+	if play == "server" {
+		server.Start(sworld, sseed, sport)
 	}
-	time.Sleep(1)
-	if play == "client" || play == "all" {
-		client.Start(name, host, port)
+	if play == "client" {
+		client.Start(name, host, port, nil)
+	}
+	if play == "all" {
+		// Single-player serves the world in this process, listening on no port unless opened to the LAN
+		world := server.StartLocal(sworld, sseed, name)
+		client.StartConn(name, world.Connect(), nil)
+		if e = world.Stop(); e != nil {
+			log.Println(e)
+		}
 	}
 }
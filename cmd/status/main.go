@@ -0,0 +1,78 @@
+// Command status asks servers for their status without joining them, and prints it with how long each took to answer.
+//
+// Run it as "status [host[:port]]...", which asks localhost:5555 when no server is given.
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+	"github.com/jeffbaumes/buildorb/pkg/headless"
+)
+
+func main() {
+	addrs := os.Args[1:]
+	if len(addrs) == 0 {
+		addrs = []string{"localhost:5555"}
+	}
+	failed := false
+	for i, addr := range addrs {
+		if i > 0 {
+			fmt.Println()
+		}
+		host, port, err := splitAddress(addr)
+		if err != nil {
+			fmt.Printf("%v: %v\n", addr, err)
+			failed = true
+			continue
+		}
+		status, latency, err := headless.QueryStatus(host, port)
+		if err != nil {
+			fmt.Printf("%v: %v\n", addr, err)
+			failed = true
+			continue
+		}
+		printStatus(addr, status, latency)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// splitAddress reads a host with an optional port, which defaults to 5555
+func splitAddress(addr string) (string, int, error) {
+	host, portText, err := net.SplitHostPort(addr)
+	if err != nil {
+		// No port was given
+		return addr, 5555, nil
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("Bad port %q", portText)
+	}
+	return host, port, nil
+}
+
+// printStatus prints a server's status
+func printStatus(addr string, s *common.Status, latency time.Duration) {
+	fmt.Printf("%v: %v, answered in %v\n", addr, s.Name, latency.Round(100*time.Microsecond))
+	if s.MOTD != "" {
+		fmt.Printf("  %v\n", s.MOTD)
+	}
+	version := fmt.Sprintf("protocol %v, build %v", s.ProtocolVersion, s.Build)
+	if s.ProtocolVersion != common.ProtocolVersion {
+		version += fmt.Sprintf(" (this is protocol %v)", common.ProtocolVersion)
+	}
+	fmt.Printf("  Version: %v\n", version)
+	fmt.Printf("  World:   %v, %v planets, up %v\n", s.World, s.Planets, s.Uptime.Round(time.Second))
+	fmt.Printf("  Players: %v", len(s.Players))
+	if len(s.Players) > 0 {
+		fmt.Printf(" (%v)", strings.Join(s.Players, ", "))
+	}
+	fmt.Println()
+}
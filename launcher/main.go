@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
 	"runtime"
 	"strconv"
@@ -12,7 +13,9 @@ import (
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/go-gl/glfw/v3.2/glfw"
 	"github.com/jeffbaumes/buildorb/pkg/client"
+	"github.com/jeffbaumes/buildorb/pkg/common"
 	"github.com/jeffbaumes/buildorb/pkg/gui"
+	"github.com/jeffbaumes/buildorb/pkg/headless"
 	"github.com/jeffbaumes/buildorb/pkg/server"
 )
 
@@ -209,18 +212,102 @@ func main() {
 			if profiles[ui.profile].world == "" {
 				client.Start(profiles[ui.profile].name, profiles[ui.profile].host, profiles[ui.profile].port, screen)
 			} else if profiles[ui.profile].world != "" {
-				go server.Start(profiles[ui.profile].world, 123, profiles[ui.profile].port)
-				time.Sleep(time.Second)
-				client.Start(profiles[ui.profile].name, profiles[ui.profile].host, profiles[ui.profile].port, screen)
+				// Serve the world in this process, so single-player listens on no port unless opened to the LAN
+				world := server.StartLocal(profiles[ui.profile].world, 123, profiles[ui.profile].name)
+				client.StartConn(profiles[ui.profile].name, world.Connect(), screen)
+				if err := world.Stop(); err != nil {
+					message.Text = err.Error()
+				}
 			}
 		}
 	}
 	play.Command = run
 
+	// Servers announcing themselves on the local network, each joined with one click
+	gui.NewLabel(screen, "LAN servers", 0.62, 0.45, 0.1)
+	lanServers := []common.DiscoveredServer{}
+	lanButtons := []*gui.Button{}
+	for i := 0; i < 4; i++ {
+		i := i
+		join := func() {
+			if i >= len(lanServers) {
+				return
+			}
+			if namee.Text == "" {
+				message.Text = "ERROR: Name needs to be something"
+				namee.Focus = true
+				return
+			}
+			saveProfile()
+			saveProfileFile()
+			client.Start(profiles[ui.profile].name, lanServers[i].Host, lanServers[i].Port, screen)
+		}
+		b := gui.NewButton(screen, "", 0.6, 0.2-0.25*float64(i), 0.38, 0.2, 0.05, join)
+		b.Hide = true
+		lanButtons = append(lanButtons, b)
+	}
+	discovery, discoveryErr := common.ListenForServers()
+	if discoveryErr != nil {
+		log.Printf("Could not listen for LAN servers: %v", discoveryErr)
+	} else {
+		defer discovery.Close()
+	}
+	refreshLAN := func() {
+		if discovery == nil {
+			return
+		}
+		lanServers = discovery.Servers()
+		for i, b := range lanButtons {
+			b.Hide = i >= len(lanServers)
+			if b.Hide {
+				continue
+			}
+			s := lanServers[i]
+			b.Text = fmt.Sprintf("%v (%v)", s.Name, s.Players)
+			if s.ProtocolVersion != common.ProtocolVersion {
+				b.Text = fmt.Sprintf("%v (other version)", s.Name)
+			}
+		}
+	}
+
+	// Ask the profile's server for its status without joining, answering on the next frame
+	pings := make(chan string, 1)
+	ping := func() {
+		saveProfile()
+		host, port := profiles[ui.profile].host, profiles[ui.profile].port
+		message.Text = "Pinging..."
+		go func() {
+			status, latency, err := headless.QueryStatus(host, port)
+			if err != nil {
+				pings <- fmt.Sprintf("Ping failed: %v", err)
+				return
+			}
+			text := fmt.Sprintf("%v: %v players, %v ms", status.Name, len(status.Players), latency.Milliseconds())
+			if status.ProtocolVersion != common.ProtocolVersion {
+				text += " (other version)"
+			}
+			if status.MOTD != "" {
+				text += " - " + status.MOTD
+			}
+			pings <- text
+		}()
+	}
+	gui.NewButton(screen, "Ping server", 0.55, -0.95, 0.4, 0.2, 0.05, ping)
+
 	loadProfile()
 	saveProfile()
 
+	lanRefreshed := time.Now()
 	for !w.ShouldClose() {
+		if time.Since(lanRefreshed) > time.Second {
+			lanRefreshed = time.Now()
+			refreshLAN()
+		}
+		select {
+		case text := <-pings:
+			message.Text = text
+		default:
+		}
 		gl.ClearColor(0.498, 1.000, 0.831, 1)
 		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
 		screen.Update()
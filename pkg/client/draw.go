@@ -2,6 +2,7 @@ package client
 
 import (
 	"log"
+	"time"
 
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/go-gl/glfw/v3.2/glfw"
@@ -74,3 +75,43 @@ func drawFrame(h float32, player *common.Player, text *scene.Text, over *scene.C
 	screen.Window.SwapBuffers()
 
 }
+
+// showMessage shows a message on an otherwise empty screen until a key or mouse button
+// is pressed or the window is closed
+func showMessage(message string) {
+	log.Println(message)
+	screen.Clear()
+	gui.NewLabel(screen, message, -0.95, 0, 0.05)
+	gui.NewLabel(screen, "Press any key to continue", -0.95, -0.2, 0.05)
+	pressed := false
+	window := screen.Window
+	window.SetInputMode(glfw.CursorMode, glfw.CursorNormal)
+	window.SetKeyCallback(func(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+		pressed = pressed || action == glfw.Press
+	})
+	window.SetMouseButtonCallback(func(w *glfw.Window, button glfw.MouseButton, action glfw.Action, mods glfw.ModifierKey) {
+		pressed = pressed || action == glfw.Press
+	})
+	for !pressed && !window.ShouldClose() {
+		gl.ClearColor(0, 0, 0, 1)
+		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+		screen.Update()
+		glfw.PollEvents()
+		screen.Window.SwapBuffers()
+		time.Sleep(time.Second / targetFPS)
+	}
+}
+
+// returnToLauncher puts back the screen and input handling the game started from
+func returnToLauncher(launcher gui.Layout) {
+	window := screen.Window
+	if window == nil {
+		return
+	}
+	screen.SetLayout(launcher)
+	window.SetInputMode(glfw.CursorMode, glfw.CursorNormal)
+	window.SetKeyCallback(screen.KeyCallBack())
+	window.SetMouseButtonCallback(screen.MouseButtonCallback())
+	window.SetCursorPosCallback(screen.CursorPosCallback())
+	window.SetScrollCallback(nil)
+}
@@ -13,6 +13,9 @@ import (
 	"github.com/jeffbaumes/buildorb/pkg/scene"
 )
 
+// chatScrollLines is how many lines of chat a page key scrolls
+const chatScrollLines = 4
+
 func cursorGrabbed(w *glfw.Window) bool {
 	return w.GetInputMode(glfw.CursorMode) == glfw.CursorDisabled
 }
@@ -138,10 +141,166 @@ func keyCallbackInventory(w *glfw.Window, key glfw.Key, scancode int, action glf
 	}
 }
 
+func keyCallbackChest(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+	if action != glfw.Press {
+		return
+	}
+	player := universe.Player
+	entity := player.OpenEntity
+	if entity == nil {
+		return
+	}
+	slot := -1
+	m := op.OptionMap
+	for i := 0; i < len(player.Hotbar); i++ {
+		if key == m[fmt.Sprintf("Slot%v", i+1)].Key {
+			slot = i
+		}
+	}
+	if key == m["Interact"].Key {
+		closeOpenEntity()
+		player.Mode = "Play"
+		w.SetInputMode(glfw.CursorMode, glfw.CursorDisabled)
+		return
+	}
+	if slot < 0 {
+		return
+	}
+	xpos, ypos := w.GetCursorPos()
+	winw, winh := w.GetSize()
+	aspect := float32(winw) / float32(winh)
+	for col := range entity.Slots {
+		sz := float32(0.03)
+		px := 1.25 * 2 * sz * (float32(col) - float32(len(entity.Slots))/2)
+		py := 1 - 0.25*aspect
+		scale := sz
+		xMin, yMin := glToPixel(w, float64(px-scale), float64(py+scale*aspect))
+		xMax, yMax := glToPixel(w, float64(px+scale), float64(py-scale*aspect))
+		if float64(xpos) >= xMin && float64(xpos) <= xMax && float64(ypos) >= yMin && float64(ypos) <= yMax {
+			swapEntitySlot(entity, col, slot)
+		}
+	}
+}
+
+// swapEntitySlot swaps a block entity slot with a hotbar slot,
+// swapping them back if the server refuses
+func swapEntitySlot(entity *common.BlockEntity, col, slot int) {
+	player := universe.Player
+	tmp := player.Hotbar[slot]
+	player.Hotbar[slot] = entity.Slots[col]
+	entity.Slots[col] = tmp
+	call := game.SetBlockEntitySlot(entity.PlanetCellIndex, col, tmp)
+	go func() {
+		call = <-call.Done
+		if call.Error != nil {
+			player.DrawText = call.Error.Error()
+			entity.Slots[col], player.Hotbar[slot] = player.Hotbar[slot], tmp
+		}
+	}()
+}
+
+// openEntity asks the server for the block entity at a cell and shows it when it arrives
+func openEntity(ind common.PlanetCellIndex) {
+	player := universe.Player
+	entity := common.BlockEntity{}
+	call := game.OpenBlockEntity(ind, &entity)
+	go func() {
+		call = <-call.Done
+		if call.Error != nil {
+			player.DrawText = call.Error.Error()
+			return
+		}
+		player.OpenEntity = &entity
+	}()
+}
+
+// closeOpenEntity tells the server the player is no longer viewing their open block entity
+func closeOpenEntity() {
+	player := universe.Player
+	if player.OpenEntity == nil {
+		return
+	}
+	game.CloseBlockEntity(player.OpenEntity.PlanetCellIndex)
+	player.OpenEntity = nil
+}
+
+// breakCell asks the server to break a mined cell. Cells without a block entity are broken right away
+// and put back if the server refuses, unless the server has changed them since. The server sends the
+// items collected as the player's inventory.
+func breakCell(ind common.PlanetCellIndex, seconds float32) {
+	player := universe.Player
+	planetRen := universe.PlanetMap[ind.Planet]
+	cell := planetRen.Planet.CellIndexToCell(ind.CellIndex)
+	if cell == nil || cell.Material == common.Air {
+		return
+	}
+	old := *cell
+	predicted := !common.HasBlockEntity(cell.Material)
+	if predicted {
+		planetRen.SetCellMaterial(ind.CellIndex, common.Air, false)
+	}
+	contents := []common.Slot{}
+	call := game.BreakCell(ind, seconds, &contents)
+	go func() {
+		call = <-call.Done
+		if call.Error != nil {
+			player.DrawText = call.Error.Error()
+			if predicted {
+				planetRen.RevertCell(ind.CellIndex, common.Cell{Material: common.Air}, old)
+			}
+		}
+	}()
+}
+
+// placeCell places the material in a hotbar slot at a cell right away, clearing the cell if the
+// server refuses and the cell still holds what was placed. The server sends the inventory again if
+// it refuses, putting the item back.
+func placeCell(ind common.PlanetCellIndex, slot int, state common.CellState) {
+	player := universe.Player
+	planetRen := universe.PlanetMap[ind.Planet]
+	hotbarslot := player.Hotbar[slot]
+	if hotbarslot.Amount <= 0 || hotbarslot.Material == common.Air {
+		return
+	}
+	player.Hotbar[slot].Amount--
+	if hotbarslot.Amount == 1 {
+		player.Hotbar[slot] = common.Slot{}
+	}
+	planetRen.SetCellMaterialState(ind.CellIndex, hotbarslot.Material, state, false)
+	call := game.SetCellMaterial(ind, hotbarslot.Material, state)
+	go func() {
+		call = <-call.Done
+		if call.Error != nil {
+			player.DrawText = call.Error.Error()
+			placed := common.Cell{Material: hotbarslot.Material, State: state}
+			planetRen.RevertCell(ind.CellIndex, placed, common.Cell{Material: common.Air})
+		}
+	}()
+}
+
+// updateMining advances mining while the destroy key is held, telling the server
+// when mining of a cell starts and breaking the cell once it has been mined long enough
+func updateMining(h float32) {
+	player := universe.Player
+	if player.Mode != "Play" {
+		player.ResetMining()
+		return
+	}
+	seconds := player.MiningSeconds
+	started, broken := player.UpdateMining(h)
+	ind := common.PlanetCellIndex{Planet: player.Planet.ID, CellIndex: player.MiningCellIndex}
+	if started {
+		seconds = 0
+		game.StartMining(ind)
+	}
+	if broken {
+		breakCell(ind, seconds+h)
+	}
+}
+
 func keyCallbackPlay(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
 	player := universe.Player
 	planet := player.Planet
-	planetRen := universe.PlanetMap[planet.ID]
 	m := op.OptionMap
 	switch action {
 	case glfw.Press:
@@ -156,6 +315,15 @@ func keyCallbackPlay(w *glfw.Window, key glfw.Key, scancode int, action glfw.Act
 		case m["Inventory"].Key:
 			player.Mode = "Inventory"
 			w.SetInputMode(glfw.CursorMode, glfw.CursorNormal)
+		case m["Interact"].Key:
+			cell := planet.CellIndexToCell(player.FocusCellIndex)
+			if cell != nil && common.HasBlockEntity(cell.Material) {
+				openEntity(common.PlanetCellIndex{Planet: planet.ID, CellIndex: player.FocusCellIndex})
+				player.Mode = "Chest"
+				w.SetInputMode(glfw.CursorMode, glfw.CursorNormal)
+			} else if cell != nil && common.IsExplosive(cell.Material) {
+				game.TriggerCell(common.PlanetCellIndex{Planet: planet.ID, CellIndex: player.FocusCellIndex})
+			}
 		case m["Forward"].Key:
 			player.ForwardVel = player.WalkVel
 		case m["Backward"].Key:
@@ -238,8 +406,7 @@ func keyCallbackPlay(w *glfw.Window, key glfw.Key, scancode int, action glfw.Act
 				for _, otherPlayer := range universe.ConnectedPeople {
 					if pos.Sub(otherPlayer.Position).Len() < 0.6 {
 						log.Println(fmt.Sprintf("Hit %v", otherPlayer.Name))
-						var ret bool
-						universe.RPC.Go("API.HitPlayer", common.HitPlayerArgs{From: player.Name, Target: otherPlayer.Name, Amount: 1}, &ret, nil)
+						game.HitPlayer(otherPlayer.Name, 1)
 						hitPlayer = true
 						break
 					}
@@ -248,9 +415,11 @@ func keyCallbackPlay(w *glfw.Window, key glfw.Key, scancode int, action glfw.Act
 					break
 				}
 				if cell != nil && cell.Material != common.Air {
-					player.Inventory[0] = common.Slot{cell.Material, 1}
-					cellIndex := planet.CartesianToCellIndex(pos)
-					planetRen.SetCellMaterial(cellIndex, common.Air, true)
+					if player.GameMode == common.Creative {
+						breakCell(common.PlanetCellIndex{Planet: planet.ID, CellIndex: planet.CartesianToCellIndex(pos)}, 0)
+					} else {
+						player.HoldingDestroy = true
+					}
 					break
 				}
 			}
@@ -268,12 +437,9 @@ func keyCallbackPlay(w *glfw.Window, key glfw.Key, scancode int, action glfw.Act
 					cell := planet.CellIndexToCell(cellIndex)
 					if cell != nil && cell.Material != common.Air {
 						if prevCellIndex.Lon != -1 {
-							hotbarslot := player.Hotbar[player.ActiveHotBarSlot]
-							player.Hotbar[player.ActiveHotBarSlot].Amount--
-							if hotbarslot.Amount == 1 {
-								player.Hotbar[player.ActiveHotBarSlot] = common.Slot{}
-							}
-							planetRen.SetCellMaterial(prevCellIndex, hotbarslot.Material, true)
+							orientation := planet.DirectionAt(prevCellIndex, player.LookDir())
+							state := common.NewCellState(orientation, 0, false)
+							placeCell(common.PlanetCellIndex{Planet: planet.ID, CellIndex: prevCellIndex}, player.ActiveHotBarSlot, state)
 						}
 						break
 					}
@@ -295,6 +461,8 @@ func keyCallbackPlay(w *glfw.Window, key glfw.Key, scancode int, action glfw.Act
 			player.RightVel = 0
 		case m["Left"].Key:
 			player.LeftVel = 0
+		case m["Destroy"].Key:
+			player.ResetMining()
 		}
 	}
 }
@@ -307,16 +475,32 @@ func keyCallback(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action,
 		keyCallbackPlay(w, key, scancode, action, mods)
 	} else {
 		if action == glfw.Press && key == glfw.KeyEscape {
+			closeOpenEntity()
 			player.Mode = "Play"
 			w.SetInputMode(glfw.CursorMode, glfw.CursorDisabled)
 		} else if m == "Inventory" {
 			keyCallbackInventory(w, key, scancode, action, mods)
+		} else if m == "Chest" {
+			keyCallbackChest(w, key, scancode, action, mods)
+		} else if m == "Text" && action != glfw.Release && (key == glfw.KeyPageUp || key == glfw.KeyPageDown) {
+			if key == glfw.KeyPageUp {
+				universe.Chat.Scroll(chatScrollLines)
+			} else {
+				universe.Chat.Scroll(-chatScrollLines)
+			}
 		} else if m == "Text" || m == "Options" {
 			guikeycallback(w, key, scancode, action, mods)
 		}
 	}
 }
 
+// scrollCallback scrolls the chat log while chat is open
+func scrollCallback(w *glfw.Window, xoff, yoff float64) {
+	if universe.Player.Mode == "Text" {
+		universe.Chat.Scroll(int(yoff))
+	}
+}
+
 func windowSizeCallback(w *glfw.Window, wd, ht int) {
 	fwidth, fheight := scene.FramebufferSize(w)
 	gl.Viewport(0, 0, int32(fwidth), int32(fheight))
@@ -330,6 +514,7 @@ func mouseButtonCallback(w *glfw.Window, button glfw.MouseButton, action glfw.Ac
 		keyCallbackPlay(w, glfw.Key(button), 0, action, mods)
 	} else {
 		if action == glfw.Press && player.Mode != "Options" {
+			closeOpenEntity()
 			player.Mode = "Play"
 			w.SetInputMode(glfw.CursorMode, glfw.CursorDisabled)
 		} else if m == "Inventory" || m == "Text" || m == "Options" {
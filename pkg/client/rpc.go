@@ -1,74 +1,68 @@
 package client
 
 import (
-	"fmt"
 	"log"
 
 	"github.com/jeffbaumes/buildorb/pkg/common"
+	"github.com/jeffbaumes/buildorb/pkg/headless"
+	"github.com/jeffbaumes/buildorb/pkg/scene"
 )
 
-// API is the RPC tag for client calls
-type API int
+// handlers keep the scene up to date with what the server sends
+func handlers() headless.Handlers {
+	return headless.Handlers{
+		Joined:             joined,
+		ChunkDelta:         chunkDelta,
+		PersonState:        updatePersonState,
+		PersonDisconnected: personDisconnected,
+		Chat: func(msg common.ChatMessage) {
+			universe.Chat.Add(msg)
+		},
+		ChatHistory: func(msgs []common.ChatMessage) {
+			universe.Chat.Add(msgs...)
+		},
+		Hit: func(args common.HitPlayerArgs) {
+			log.Printf("Hit by %v", args.From)
+		},
+	}
+}
 
-// SetCellMaterial sets the material for a particular cell
-func (api *API) SetCellMaterial(args *common.RPCSetCellMaterialArgs, ret *bool) error {
-	universe.PlanetMap[args.Planet].SetCellMaterial(args.Index, args.Material, false)
-	*ret = true
-	return nil
+// joined sets up the scene for the player and planets
+func joined(c *headless.Client) {
+	universe = scene.NewUniverse(c.Player, c)
+	for _, planet := range c.Planets {
+		universe.AddPlanet(scene.NewPlanet(planet))
+	}
 }
 
-// GetPersonState returns this client's logged in user state
-func (api *API) GetPersonState(args *int, ret *common.PlayerState) error {
-	ret.Name = universe.Player.Name
-	ret.Position = universe.Player.Location()
-	return nil
+// chunkDelta marks the chunks changed by a delta for redraw
+func chunkDelta(planet int, applied []common.CellChange) {
+	planetRen := universe.PlanetMap[planet]
+	if planetRen != nil {
+		planetRen.CellsChanged(applied)
+	}
 }
 
-// PersonDisconnected notifies a client that a player has disconnected
-func (api *API) PersonDisconnected(name *string, ret *bool) error {
+// personDisconnected removes a player who has disconnected or is out of view
+func personDisconnected(name string) {
 	var validPeople []*common.PlayerState
-	*ret = false
 	for _, p := range universe.ConnectedPeople {
-		if p.Name != *name {
+		if p.Name != name {
 			validPeople = append(validPeople, p)
-			*ret = true
 		}
 	}
 	universe.ConnectedPeople = validPeople
-	return nil
 }
 
-// UpdatePersonState updates another person's state
-func (api *API) UpdatePersonState(state *common.PlayerState, ret *bool) error {
-	if state.Name == universe.Player.Name {
-		return nil
-	}
-	found := false
+// updatePersonState updates another person's state
+func updatePersonState(state *common.PlayerState) {
 	for _, c := range universe.ConnectedPeople {
 		if c.Name == state.Name {
+			c.Planet = state.Planet
 			c.Position = state.Position
 			c.LookDir = state.LookDir
-			found = true
-			break
+			return
 		}
 	}
-	if !found {
-		universe.ConnectedPeople = append(universe.ConnectedPeople, state)
-	}
-	return nil
-}
-
-// SendText sends a player text
-func (api *API) SendText(text *string, ret *bool) error {
-	universe.Player.DrawText = *text
-	*ret = true
-	return nil
-}
-
-// HitPlayer damages a playerv
-func (api *API) HitPlayer(args *common.HitPlayerArgs, ret *bool) error {
-	log.Println(fmt.Sprintf("Hit by %v", args.From))
-	universe.Player.UpdateHealth(-args.Amount)
-	*ret = true
-	return nil
+	universe.ConnectedPeople = append(universe.ConnectedPeople, state)
 }
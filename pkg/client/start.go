@@ -2,15 +2,14 @@ package client
 
 import (
 	"fmt"
+	"log"
 	"net"
-	"net/rpc"
 	"runtime"
 	"time"
 
 	"github.com/go-gl/glfw/v3.2/glfw"
-	"github.com/hashicorp/yamux"
-	"github.com/jeffbaumes/buildorb/pkg/common"
 	"github.com/jeffbaumes/buildorb/pkg/gui"
+	"github.com/jeffbaumes/buildorb/pkg/headless"
 	"github.com/jeffbaumes/buildorb/pkg/scene"
 )
 
@@ -23,75 +22,63 @@ var (
 	universe *scene.Universe
 	screen   *gui.Screen
 	op       *scene.Options
+	game     *headless.Client
 )
 
-// Start starts a client with the given username, host, and port
+// Start starts a client with the given username, host, and port.
+// It returns to the screen it was started from when the game ends or the connection is lost.
 func Start(username, host string, port int, scr *gui.Screen) {
-	screen = scr
-	screen.Clear()
-	if host == "" {
-		host = "localhost"
-	}
-	if port == 0 {
-		port = 5555
-	}
-	window := screen.Window
-	if screen.Window == nil {
-		runtime.LockOSThread()
+	play(username, scr, func() (*headless.Client, error) {
+		return headless.Dial(host, port, username, playerToken(username), handlers())
+	})
+}
+
+// StartConn starts a client with the given username over an open connection,
+// such as one to a world served in the same process
+func StartConn(username string, conn net.Conn, scr *gui.Screen) {
+	play(username, scr, func() (*headless.Client, error) {
+		return headless.Connect(conn, username, playerToken(username), handlers())
+	})
+}
 
-		window = initGlfw()
+// play joins a server and runs the game until the window closes or the connection is lost
+func play(username string, scr *gui.Screen, join func() (*headless.Client, error)) {
+	if scr == nil {
+		// Started without a launcher, so open a window of our own
+		runtime.LockOSThread()
+		window := initGlfw()
 		initOpenGL()
+		defer glfw.Terminate()
+		scr = gui.NewScreen(window)
+		scr.InitGui("textures/font/font.png", "textures/font/font.json", "textures/button.png", "textures/entry.png", 0.3)
+	} else {
+		defer returnToLauncher(scr.Layout())
 	}
+	screen = scr
+	screen.Clear()
+	window := screen.Window
 
-	defer glfw.Terminate()
-	conn, err := net.Dial("tcp", fmt.Sprintf("%v:%v", host, port))
+	c, err := join()
 	if err != nil {
-		panic(err)
-	}
-
-	// Setup client side of yamux
-	cmux, e := yamux.Client(conn, nil)
-	if e != nil {
-		panic(e)
-	}
-	stream, e := cmux.Open()
-	if e != nil {
-		panic(e)
-	}
-	cRPC := rpc.NewClient(stream)
-
-	player := common.NewPlayer(username)
-	universe = scene.NewUniverse(player, cRPC)
-
-	planetStates := []*common.PlanetState{}
-	e = cRPC.Call("API.GetPlanetStates", 0, &planetStates)
-	if e != nil {
-		panic(e)
-	}
-	for _, state := range planetStates {
-		planet := common.NewPlanet(*state, cRPC, nil)
-		planetRen := scene.NewPlanet(planet)
-		universe.AddPlanet(planetRen)
+		showMessage(fmt.Sprintf("Could not join server: %v", err))
+		return
 	}
+	defer c.Close()
+	game = c
+	log.Printf("Joined server build %v with capabilities %v", c.Reply.Build, c.Reply.Capabilities)
 
+	player := c.Player
 	op = scene.NewOptions(screen)
-	player.Planet = universe.PlanetMap[0].Planet
-	player.Spawn()
+	c.SetReconnectTimeout(time.Duration(op.ReconnectSeconds) * time.Second)
+	c.Spawn(0)
 
 	over := scene.NewCrosshair()
 	text := &scene.Text{}
 	bar := scene.NewHotbar()
 	health := scene.NewHealth()
 	player.Mode = "Play"
-	// Setup server connection
-	smuxConn, e := cmux.Accept()
-	if e != nil {
-		panic(e)
-	}
-	s := rpc.NewServer()
-	clientAPI := new(API)
-	s.Register(clientAPI)
-	go s.ServeConn(smuxConn)
+	reconnecting := gui.NewLabel(screen, "Connection lost, reconnecting...", -0.95, 0.9, 0.05)
+	reconnecting.Hide = true
 
 	peopleRen := scene.NewPlayers(&universe.ConnectedPeople)
 	focusRen := scene.NewFocusCell()
@@ -101,29 +88,29 @@ func Start(username, host string, port int, scr *gui.Screen) {
 	window.SetCursorPosCallback(cursorPosCallback())
 	window.SetSizeCallback(windowSizeCallback)
 	window.SetMouseButtonCallback(mouseButtonCallback)
+	window.SetScrollCallback(scrollCallback)
 
-	startTime := time.Now()
-	t := startTime
-	syncT := t
+	t := time.Now()
 	for !window.ShouldClose() {
 		println("hey dad your funny!!!!")
 		h := float32(time.Since(t)) / float32(time.Second)
 		t = time.Now()
-		elapsedSeconds := float64(time.Since(startTime)) / float64(time.Second)
-
-		drawFrame(h, player, text, over, peopleRen, focusRen, bar, health, screen, elapsedSeconds, op)
+		select {
+		case <-c.Closed():
+			message := "Lost connection to server"
+			if reason := c.DisconnectReason(); reason != "" {
+				message = "Disconnected: " + reason
+			}
+			showMessage(message)
+			return
+		default:
+		}
+		reconnecting.Hide = !c.Reconnecting()
+		drawFrame(h, player, text, over, peopleRen, focusRen, bar, health, screen, c.UniverseTime(), op)
 
-		player.UpdatePosition(h)
+		c.Update(h)
+		updateMining(h)
 
-		if float64(time.Since(syncT))/float64(time.Second) > 0.05 {
-			syncT = time.Now()
-			var ret bool
-			cRPC.Go("API.UpdatePersonState", &common.PlayerState{
-				Name:     player.Name,
-				Position: player.Location(),
-				LookDir:  player.LookDir(),
-			}, &ret, nil)
-		}
 		time.Sleep(time.Second/time.Duration(targetFPS) - time.Since(t))
 	}
 }
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// tokensFile holds the secret token of each player name used on this computer,
+// as name=token pairs separated by semicolons. Servers register a name to the
+// first token that joins with it, so keep this file private.
+const tokensFile = "tokens.txt"
+
+// playerToken returns the token for a player name, creating and saving one on first use
+func playerToken(name string) string {
+	tokens := make(map[string]string)
+	b, _ := ioutil.ReadFile(tokensFile)
+	for _, pair := range strings.Split(string(b), ";") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) == 2 && kv[0] != "" {
+			tokens[kv[0]] = kv[1]
+		}
+	}
+	if token, ok := tokens[name]; ok {
+		return token
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	tokens[name] = hex.EncodeToString(secret)
+	strs := []string{}
+	for n, t := range tokens {
+		strs = append(strs, fmt.Sprintf("%v=%v", n, t))
+	}
+	err := ioutil.WriteFile(tokensFile, []byte(strings.Join(strs, ";")), 0600)
+	if err != nil {
+		panic(err)
+	}
+	return tokens[name]
+}
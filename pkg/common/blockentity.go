@@ -0,0 +1,64 @@
+package common
+
+// ChestSlots is the number of slots in a chest
+const (
+	ChestSlots = 12
+)
+
+// Rules for what happens to the contents of a block entity when its block is broken
+const (
+	DropContents = iota
+	KeepContents
+)
+
+// PlanetCellIndex stores the planet, latitude, longitude, and altitude index of a cell
+type PlanetCellIndex struct {
+	Planet int
+	CellIndex
+}
+
+// BlockEntity is a server-side object attached to a single cell, such as the contents of a chest
+type BlockEntity struct {
+	PlanetCellIndex
+	Material int
+	Slots    []Slot
+}
+
+// BlockEntityArgs are the arguments for opening, closing, and breaking block entities
+type BlockEntityArgs struct {
+	From string
+	PlanetCellIndex
+}
+
+// BlockEntitySlotArgs are the arguments for changing one slot of a block entity
+type BlockEntitySlotArgs struct {
+	BlockEntityArgs
+	Slot     int
+	Contents Slot
+}
+
+// HasBlockEntity returns whether cells of a material have an associated block entity
+func HasBlockEntity(material int) bool {
+	return material == Chest
+}
+
+// NewBlockEntity creates an empty block entity for a cell of the given material
+func NewBlockEntity(ind PlanetCellIndex, material int) *BlockEntity {
+	e := BlockEntity{}
+	e.PlanetCellIndex = ind
+	e.Material = material
+	if material == Chest {
+		e.Slots = make([]Slot, ChestSlots)
+	}
+	return &e
+}
+
+// Empty returns whether all the slots of a block entity are empty
+func (e *BlockEntity) Empty() bool {
+	for _, s := range e.Slots {
+		if s.Amount > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package common
+
+import "github.com/go-gl/mathgl/mgl32"
+
+// Directions relative to the local up vector of a cell
+const (
+	DirUp = iota
+	DirDown
+	DirLonPos
+	DirLonNeg
+	DirLatPos
+	DirLatNeg
+)
+
+// CellState is the optional block state of a cell, packed into one byte.
+// The low three bits are the orientation, the next bit is whether the block
+// is open, and the high four bits are a material-specific variant.
+type CellState uint8
+
+// NewCellState packs an orientation, variant and open flag into a cell state
+func NewCellState(orientation, variant int, open bool) CellState {
+	s := CellState(orientation&0x7) | CellState(variant&0xf)<<4
+	if open {
+		s |= 0x8
+	}
+	return s
+}
+
+// Orientation returns the direction the cell faces, one of the Dir constants
+func (s CellState) Orientation() int {
+	o := int(s & 0x7)
+	if o > DirLatNeg {
+		return DirUp
+	}
+	return o
+}
+
+// Open returns whether the cell is open, such as an open door
+func (s CellState) Open() bool {
+	return s&0x8 != 0
+}
+
+// Variant returns the material-specific variant of the cell
+func (s CellState) Variant() int {
+	return int(s >> 4)
+}
+
+// WithOpen returns a copy of the state with the open flag set
+func (s CellState) WithOpen(open bool) CellState {
+	return NewCellState(s.Orientation(), s.Variant(), open)
+}
+
+// IsOriented returns whether the orientation of a material changes how it is drawn
+func IsOriented(material int) bool {
+	return material == BlueWood
+}
+
+// DirectionAt returns the direction relative to the local up vector at a cell
+// that is closest to a world-space direction
+func (p *Planet) DirectionAt(ind CellIndex, v mgl32.Vec3) int {
+	center := p.CellIndexToCartesian(ind)
+	neighbors := [6]CellIndex{
+		DirUp:     {Lon: ind.Lon, Lat: ind.Lat, Alt: ind.Alt + 1},
+		DirDown:   {Lon: ind.Lon, Lat: ind.Lat, Alt: ind.Alt - 1},
+		DirLonPos: {Lon: ind.Lon + 1, Lat: ind.Lat, Alt: ind.Alt},
+		DirLonNeg: {Lon: ind.Lon - 1, Lat: ind.Lat, Alt: ind.Alt},
+		DirLatPos: {Lon: ind.Lon, Lat: ind.Lat + 1, Alt: ind.Alt},
+		DirLatNeg: {Lon: ind.Lon, Lat: ind.Lat - 1, Alt: ind.Alt},
+	}
+	best := DirUp
+	bestDot := float32(-2)
+	for dir, n := range neighbors {
+		d := p.CellIndexToCartesian(n).Sub(center).Normalize().Dot(v.Normalize())
+		if d > bestDot {
+			best = dir
+			bestDot = d
+		}
+	}
+	return best
+}
@@ -0,0 +1,41 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+// Chat channels
+const (
+	ChatPublic  = iota
+	ChatPrivate = iota
+	ChatServer  = iota
+	ChatNotice  = iota
+)
+
+// MaxChatLength is the longest chat message the server accepts
+const MaxChatLength = 256
+
+// ChatMessage is a chat message along with who sent it, when, and on which channel.
+// To is only set for private messages.
+type ChatMessage struct {
+	From    string
+	To      string
+	Channel int
+	Time    time.Time
+	Text    string
+}
+
+// String formats a message for the chat log
+func (m ChatMessage) String() string {
+	stamp := m.Time.Local().Format("15:04")
+	switch m.Channel {
+	case ChatPrivate:
+		return fmt.Sprintf("[%v] %v -> %v: %v", stamp, m.From, m.To, m.Text)
+	case ChatServer:
+		return fmt.Sprintf("[%v] [Server] %v", stamp, m.Text)
+	case ChatNotice:
+		return m.Text
+	}
+	return fmt.Sprintf("[%v] %v: %v", stamp, m.From, m.Text)
+}
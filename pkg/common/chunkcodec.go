@@ -0,0 +1,94 @@
+package common
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io/ioutil"
+)
+
+// Chunk batches: a client asks for up to MaxChunkBatch chunks in one request,
+// and keeps at most MaxChunkRequests requests in flight
+const (
+	MaxChunkBatch    = 32
+	MaxChunkRequests = 2
+)
+
+// ChunkBatchArgs are the arguments for asking for many chunks of a planet at once
+type ChunkBatchArgs struct {
+	Planet int
+	Chunks []ChunkIndex
+
+	// Seqs, if given, are the numbers of the last deltas the client applied to each chunk.
+	// Chunks that have not changed since are subscribed to without being sent again.
+	Seqs []uint64
+}
+
+// EncodedChunk is a chunk packed and compressed for sending, along with the number of its last delta
+type EncodedChunk struct {
+	ChunkIndex
+	Seq  uint64
+	Data []byte
+}
+
+// Pack returns the materials of a chunk's cells followed by their states, one byte each
+func (c *Chunk) Pack() []byte {
+	n := len(c.Cells) * len(c.Cells[0]) * ChunkSize
+	packed := make([]byte, 0, 2*n)
+	for _, lon := range c.Cells {
+		for _, lat := range lon {
+			for _, cell := range lat {
+				packed = append(packed, byte(cell.Material))
+			}
+		}
+	}
+	for _, lon := range c.Cells {
+		for _, lat := range lon {
+			for _, cell := range lat {
+				packed = append(packed, byte(cell.State))
+			}
+		}
+	}
+	return packed
+}
+
+// CompressChunk compresses a packed chunk
+func CompressChunk(packed []byte) []byte {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		panic(err)
+	}
+	w.Write(packed)
+	w.Close()
+	return buf.Bytes()
+}
+
+// DecodeChunk unpacks an encoded chunk of the planet
+func (p *Planet) DecodeChunk(e EncodedChunk) (*Chunk, error) {
+	packed, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(e.Data)))
+	if err != nil {
+		return nil, err
+	}
+	lonCells, latCells := p.LonLatCellsInChunkIndex(e.ChunkIndex)
+	n := lonCells * latCells * ChunkSize
+	if len(packed) != 2*n {
+		return nil, errors.New("Chunk data has the wrong size")
+	}
+	chunk := Chunk{Seq: e.Seq}
+	cells := make([]Cell, n)
+	chunk.Cells = make([][][]*Cell, lonCells)
+	i := 0
+	for lonIndex := range chunk.Cells {
+		chunk.Cells[lonIndex] = make([][]*Cell, latCells)
+		for latIndex := range chunk.Cells[lonIndex] {
+			chunk.Cells[lonIndex][latIndex] = make([]*Cell, ChunkSize)
+			for altIndex := range chunk.Cells[lonIndex][latIndex] {
+				cells[i] = Cell{Material: int(packed[i]), State: CellState(packed[n+i])}
+				chunk.Cells[lonIndex][latIndex][altIndex] = &cells[i]
+				i++
+			}
+		}
+	}
+	return &chunk, nil
+}
@@ -0,0 +1,227 @@
+package common
+
+import "time"
+
+// ChunkDelta is a batch of changes to the cells of one chunk. The deltas of each chunk
+// are numbered one after another, so a client can tell when it has missed one.
+type ChunkDelta struct {
+	PlanetChunkIndex
+	Seq     uint64
+	Changes []CellChange
+}
+
+// subscribeChunk queues a chunk to be asked for from the server, which then sends its
+// changes as deltas. Deltas that arrive before the chunk are held and applied once it arrives.
+func (p *Planet) subscribeChunk(ind ChunkIndex) {
+	p.ChunksMutex.Lock()
+	if c := p.Chunks[ind]; c == nil || !c.WaitingForData {
+		p.Chunks[ind] = &Chunk{WaitingForData: true}
+		p.chunkQueue = append(p.chunkQueue, ind)
+	}
+	p.ChunksMutex.Unlock()
+}
+
+// SendChunkRequests asks the server for queued chunks in batches. When waiting, it returns
+// once every chunk has arrived. Otherwise it keeps at most MaxChunkRequests requests in flight
+// and sends the rest of the queue on a later call.
+func (p *Planet) SendChunkRequests(wait bool) {
+	if p.rpc == nil {
+		return
+	}
+	for {
+		p.ChunksMutex.Lock()
+		if len(p.chunkQueue) == 0 || (!wait && p.chunkRequests >= MaxChunkRequests) {
+			p.ChunksMutex.Unlock()
+			return
+		}
+		batch := []ChunkIndex{}
+		inBatch := make(map[ChunkIndex]bool)
+		for len(p.chunkQueue) > 0 && len(batch) < MaxChunkBatch {
+			ind := p.chunkQueue[0]
+			p.chunkQueue = p.chunkQueue[1:]
+
+			// Skip chunks unloaded since they were queued
+			if c := p.Chunks[ind]; c != nil && c.WaitingForData && !inBatch[ind] {
+				batch = append(batch, ind)
+				inBatch[ind] = true
+			}
+		}
+		if len(batch) > 0 && !wait {
+			p.chunkRequests++
+		}
+		p.ChunksMutex.Unlock()
+		if len(batch) > 0 {
+			p.requestChunks(batch, wait)
+		}
+	}
+}
+
+// requestChunks asks the server for a batch of chunks, waiting for them or receiving them in the background
+func (p *Planet) requestChunks(inds []ChunkIndex, wait bool) {
+	args := ChunkBatchArgs{Planet: p.ID, Chunks: inds}
+	encoded := []EncodedChunk{}
+	start := time.Now()
+	if wait {
+		e := p.rpc.Call("API.SubscribeChunks", args, &encoded)
+		if e != nil {
+			// The chunks are forgotten and asked for again, once the connection is back if it dropped
+			encoded = nil
+		} else if ChunksReceived != nil {
+			ChunksReceived(len(encoded), time.Since(start))
+		}
+		p.receiveChunks(inds, encoded)
+		return
+	}
+	call := p.rpc.Go("API.SubscribeChunks", args, &encoded, nil)
+	go func() {
+		call = <-call.Done
+		p.ChunksMutex.Lock()
+		p.chunkRequests--
+		p.ChunksMutex.Unlock()
+		if call.Error != nil {
+			encoded = nil
+		} else if ChunksReceived != nil {
+			ChunksReceived(len(encoded), time.Since(start))
+		}
+		p.receiveChunks(inds, encoded)
+	}()
+}
+
+// receiveChunks puts chunks from the server in place of the chunks waiting for them and
+// applies any deltas that arrived in the meantime. Chunks that did not arrive are forgotten,
+// so they are asked for again.
+func (p *Planet) receiveChunks(inds []ChunkIndex, encoded []EncodedChunk) {
+	p.DeltaMutex.Lock()
+	defer p.DeltaMutex.Unlock()
+	arrived := make(map[ChunkIndex]*Chunk)
+	for _, e := range encoded {
+		chunk, err := p.DecodeChunk(e)
+		if err == nil {
+			arrived[e.ChunkIndex] = chunk
+		}
+	}
+	for _, ind := range inds {
+		p.ChunksMutex.Lock()
+		waiting := p.Chunks[ind]
+		if waiting == nil || !waiting.WaitingForData {
+			// Unloaded before it arrived
+			p.ChunksMutex.Unlock()
+			continue
+		}
+		chunk := arrived[ind]
+		if chunk == nil {
+			delete(p.Chunks, ind)
+			p.ChunksMutex.Unlock()
+			continue
+		}
+		p.Chunks[ind] = chunk
+		p.ChunksMutex.Unlock()
+		for _, delta := range waiting.pending {
+			p.applyChunkDelta(delta)
+		}
+	}
+}
+
+// ResubscribeChunks asks the server again for the changes to every chunk the planet holds,
+// after connecting anew. If the server still numbers chunk deltas as before, only the chunks
+// that changed in the meantime are sent again. Otherwise every chunk is.
+func (p *Planet) ResubscribeChunks(sameNumbers bool) error {
+	p.ChunksMutex.Lock()
+	inds := []ChunkIndex{}
+	seqs := []uint64{}
+	for ind, chunk := range p.Chunks {
+		if !chunk.WaitingForData {
+			inds = append(inds, ind)
+			seqs = append(seqs, chunk.Seq)
+		}
+	}
+	p.ChunksMutex.Unlock()
+	for start := 0; start < len(inds); start += MaxChunkBatch {
+		end := start + MaxChunkBatch
+		if end > len(inds) {
+			end = len(inds)
+		}
+		args := ChunkBatchArgs{Planet: p.ID, Chunks: inds[start:end]}
+		if sameNumbers {
+			args.Seqs = seqs[start:end]
+		}
+		encoded := []EncodedChunk{}
+		if err := p.rpc.Call("API.SubscribeChunks", args, &encoded); err != nil {
+			return err
+		}
+		p.replaceChunks(encoded)
+	}
+	return nil
+}
+
+// replaceChunks puts chunks sent again by the server in place of the ones the planet holds
+func (p *Planet) replaceChunks(encoded []EncodedChunk) {
+	p.DeltaMutex.Lock()
+	defer p.DeltaMutex.Unlock()
+	for _, e := range encoded {
+		chunk, err := p.DecodeChunk(e)
+		if err != nil {
+			continue
+		}
+		p.ChunksMutex.Lock()
+		old := p.Chunks[e.ChunkIndex]
+		if old == nil {
+			// Unloaded in the meantime
+			p.ChunksMutex.Unlock()
+			continue
+		}
+		p.Chunks[e.ChunkIndex] = chunk
+		p.ChunksMutex.Unlock()
+		if old.WaitingForData {
+			for _, delta := range old.pending {
+				p.applyChunkDelta(delta)
+			}
+		}
+	}
+}
+
+// UnloadChunk forgets a chunk and tells the server to stop sending its changes
+func (p *Planet) UnloadChunk(ind ChunkIndex) {
+	p.ChunksMutex.Lock()
+	delete(p.Chunks, ind)
+	p.ChunksMutex.Unlock()
+	if p.rpc != nil {
+		var ret bool
+		p.rpc.Go("API.UnsubscribeChunk", PlanetChunkIndex{Planet: p.ID, ChunkIndex: ind}, &ret, nil)
+	}
+}
+
+// ApplyChunkDelta applies a delta that follows the last one applied to its chunk,
+// returning the changes that modified a cell. Deltas already applied are ignored,
+// and if any were missed the chunk is fetched again.
+func (p *Planet) ApplyChunkDelta(delta *ChunkDelta) []CellChange {
+	p.DeltaMutex.Lock()
+	defer p.DeltaMutex.Unlock()
+	return p.applyChunkDelta(delta)
+}
+
+func (p *Planet) applyChunkDelta(delta *ChunkDelta) []CellChange {
+	p.ChunksMutex.Lock()
+	chunk := p.Chunks[delta.ChunkIndex]
+	if chunk == nil {
+		p.ChunksMutex.Unlock()
+		return nil
+	}
+	if chunk.WaitingForData {
+		chunk.pending = append(chunk.pending, delta)
+		p.ChunksMutex.Unlock()
+		return nil
+	}
+	if delta.Seq <= chunk.Seq {
+		p.ChunksMutex.Unlock()
+		return nil
+	}
+	if delta.Seq > chunk.Seq+1 {
+		p.ChunksMutex.Unlock()
+		p.subscribeChunk(delta.ChunkIndex)
+		return nil
+	}
+	chunk.Seq = delta.Seq
+	p.ChunksMutex.Unlock()
+	return p.SetCellMaterials(delta.Changes)
+}
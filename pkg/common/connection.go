@@ -0,0 +1,32 @@
+package common
+
+import (
+	"net/rpc"
+	"time"
+
+	"github.com/hashicorp/yamux"
+)
+
+// Connection timing: each step of connecting must finish within HandshakeTimeout,
+// and a heartbeat every HeartbeatInterval closes connections whose other end stops answering
+const (
+	HandshakeTimeout  = 30 * time.Second
+	HeartbeatInterval = 5 * time.Second
+)
+
+// MuxConfig returns the yamux settings shared by clients and servers
+func MuxConfig() *yamux.Config {
+	config := yamux.DefaultConfig()
+	config.EnableKeepAlive = true
+	config.KeepAliveInterval = HeartbeatInterval
+	config.ConnectionWriteTimeout = HandshakeTimeout / 3
+	config.StreamOpenTimeout = HandshakeTimeout
+	return config
+}
+
+// RPCClient makes calls on the other end of a connection. It is met by *rpc.Client,
+// and by clients that carry on over a new connection when theirs drops.
+type RPCClient interface {
+	Call(serviceMethod string, args interface{}, reply interface{}) error
+	Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call
+}
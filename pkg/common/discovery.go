@@ -0,0 +1,128 @@
+package common
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LAN discovery: servers that turn it on broadcast an announcement on the local network
+// every DiscoveryInterval, and launchers listen for them on DiscoveryPort.
+const (
+	DiscoveryPort     = 5556
+	DiscoveryInterval = 2 * time.Second
+)
+
+// discoveryMagic starts every announcement so other traffic on the port is ignored
+var discoveryMagic = []byte("buildorb-lan\n")
+
+// Announcement is what a server tells the local network about itself
+type Announcement struct {
+	Name            string
+	World           string
+	Players         int
+	Port            int
+	ProtocolVersion int
+	Build           string
+}
+
+// Encode returns an announcement as a datagram
+func (a Announcement) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(discoveryMagic)
+	if err := gob.NewEncoder(&buf).Encode(a); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeAnnouncement reads an announcement from a datagram
+func DecodeAnnouncement(data []byte) (Announcement, error) {
+	var a Announcement
+	if !bytes.HasPrefix(data, discoveryMagic) {
+		return a, errors.New("Not an announcement")
+	}
+	err := gob.NewDecoder(bytes.NewReader(data[len(discoveryMagic):])).Decode(&a)
+	return a, err
+}
+
+// DiscoveredServer is a server heard on the local network
+type DiscoveredServer struct {
+	Announcement
+	Host string
+	Seen time.Time
+}
+
+// Discovery listens for servers announcing themselves on the local network
+type Discovery struct {
+	conn    *net.UDPConn
+	mutex   sync.Mutex
+	servers map[string]*DiscoveredServer
+}
+
+// ListenForServers starts listening for server announcements
+func ListenForServers() (*Discovery, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: DiscoveryPort})
+	if err != nil {
+		return nil, err
+	}
+	d := &Discovery{conn: conn, servers: make(map[string]*DiscoveredServer)}
+	go d.receive()
+	return d, nil
+}
+
+// receive records announcements until the connection is closed
+func (d *Discovery) receive() {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		a, err := DecodeAnnouncement(buf[:n])
+		if err != nil {
+			continue
+		}
+		server := &DiscoveredServer{Announcement: a, Host: addr.IP.String(), Seen: time.Now()}
+		d.mutex.Lock()
+		d.servers[server.Address()] = server
+		d.mutex.Unlock()
+	}
+}
+
+// Address returns the host and port to connect to a discovered server
+func (s *DiscoveredServer) Address() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// Servers returns the servers heard from recently, sorted by name
+func (d *Discovery) Servers() []DiscoveredServer {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	servers := []DiscoveredServer{}
+	for addr, s := range d.servers {
+		// Forget servers that have missed a few announcements
+		if time.Since(s.Seen) > 3*DiscoveryInterval {
+			delete(d.servers, addr)
+			continue
+		}
+		servers = append(servers, *s)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		if servers[i].Name != servers[j].Name {
+			return servers[i].Name < servers[j].Name
+		}
+		return servers[i].Address() < servers[j].Address()
+	})
+	return servers
+}
+
+// Close stops listening
+func (d *Discovery) Close() error {
+	return d.conn.Close()
+}
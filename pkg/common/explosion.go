@@ -0,0 +1,101 @@
+package common
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// ExplosionRadius is how far an explosion reaches in cells, ExplosionPower is its blast
+// power at the center, and ExplosionDamage is the health it takes from a player at the center
+const (
+	ExplosionRadius = 4
+	ExplosionPower  = 5
+	ExplosionDamage = 6
+)
+
+// MaterialBlastResistance is the blast power needed to destroy each material
+var MaterialBlastResistance = []float32{
+	0,   // air
+	1,   // grass
+	1,   // dirt
+	3,   // stone
+	3,   // moon
+	4,   // asteroid
+	100, // sun
+	3,   // blue_block
+	1,   // blue_sand
+	3,   // purple_block
+	1,   // purple_sand
+	3,   // red_block
+	1,   // red_sand
+	3,   // yellow_block
+	1,   // yellow_sand
+	100, // water
+	2,   // blue_wood
+	2,   // chest
+	0,   // explosive
+}
+
+// CellChange is a new material and block state for one cell
+type CellChange struct {
+	Index    CellIndex
+	Material int
+	State    CellState
+}
+
+// TriggerArgs are the arguments for triggering a cell, such as lighting an explosive
+type TriggerArgs struct {
+	From string
+	PlanetCellIndex
+}
+
+// IsExplosive returns whether cells of a material explode when triggered
+func IsExplosive(material int) bool {
+	return material == Explosive
+}
+
+// BlastCells returns the cells destroyed by an explosion centered on a cell.
+// Blast power falls off with distance, so resistant materials only break close to the center.
+func (p *Planet) BlastCells(center CellIndex) []CellIndex {
+	centerPos := p.CellIndexToCartesian(center)
+	lonCells, latCells := p.LonLatCellsInChunkIndex(p.CellIndexToChunkIndex(center))
+	lonRange := ExplosionRadius * ChunkSize / lonCells
+	latRange := ExplosionRadius * ChunkSize / latCells
+	seen := make(map[*Cell]bool)
+	var cells []CellIndex
+	for lon := center.Lon - lonRange; lon <= center.Lon+lonRange; lon++ {
+		for lat := center.Lat - latRange; lat <= center.Lat+latRange; lat++ {
+			for alt := center.Alt - ExplosionRadius; alt <= center.Alt+ExplosionRadius; alt++ {
+				ind, ok := p.wrapCellIndex(CellIndex{Lon: lon, Lat: lat, Alt: alt})
+				if !ok {
+					continue
+				}
+				cell := p.CellIndexToCell(ind)
+				if cell == nil || cell.Material == Air || seen[cell] {
+					continue
+				}
+				seen[cell] = true
+				power := ExplosionPower * (1 - p.blastDistance(centerPos, p.CellIndexToCartesian(ind))/ExplosionRadius)
+				if power > MaterialBlastResistance[cell.Material] {
+					cells = append(cells, ind)
+				}
+			}
+		}
+	}
+	return cells
+}
+
+// BlastDamage returns the damage an explosion centered on a cell does to a player at a location
+func (p *Planet) BlastDamage(center CellIndex, pos mgl32.Vec3) int {
+	d := p.blastDistance(p.CellIndexToCartesian(center), pos)
+	if d >= ExplosionRadius {
+		return 0
+	}
+	return int(math.Ceil(float64(ExplosionDamage * (1 - d/ExplosionRadius))))
+}
+
+// blastDistance returns the distance between two points in units of cell height
+func (p *Planet) blastDistance(a, b mgl32.Vec3) float32 {
+	return a.Sub(b).Len() / float32(p.AltDelta)
+}
@@ -0,0 +1,123 @@
+package common
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ProtocolVersion must match between client and server. Increase it whenever
+// a type sent over RPC changes or an API call is added, removed or changed.
+const ProtocolVersion = 8
+
+// Build is the version of this build, which can be set when linking with
+// -ldflags "-X github.com/jeffbaumes/buildorb/pkg/common.Build=<version>"
+var Build = "dev"
+
+// Capabilities are the optional features this build supports
+var Capabilities = []string{"blockstate", "blockentity", "mining", "explosives"}
+
+// Hello is the first message a client sends, on its own stream, before any API call
+type Hello struct {
+	ProtocolVersion int
+	Build           string
+	Capabilities    []string
+	Name            string
+
+	// Token is the player's secret, which the server checks against the account for Name
+	Token string
+
+	// StatusOnly asks for the server's status instead of joining
+	StatusOnly bool
+
+	// Resume asks to carry on as the player was before their connection dropped,
+	// replacing their session if the server still has it
+	Resume bool
+}
+
+// HelloReply is the server's answer to a hello, listing the capabilities both sides support
+type HelloReply struct {
+	Accepted        bool
+	Message         string
+	ProtocolVersion int
+	Build           string
+	Capabilities    []string
+
+	// Status answers a hello that only asked for the server's status
+	Status *Status
+
+	// Instance identifies this run of the server's world. Chunks keep the numbers
+	// of their deltas from one connection to the next only while it is the same.
+	Instance int64
+}
+
+// NewHello creates the hello for this build
+func NewHello(name, token string) Hello {
+	return Hello{
+		ProtocolVersion: ProtocolVersion,
+		Build:           Build,
+		Capabilities:    Capabilities,
+		Name:            name,
+		Token:           token,
+	}
+}
+
+// CheckHello returns the server's reply to a client's hello
+func CheckHello(hello Hello) HelloReply {
+	reply := HelloReply{
+		Accepted:        true,
+		ProtocolVersion: ProtocolVersion,
+		Build:           Build,
+		Capabilities:    SharedCapabilities(Capabilities, hello.Capabilities),
+	}
+	if hello.ProtocolVersion != ProtocolVersion {
+		reply.Accepted = false
+		reply.Message = fmt.Sprintf("Server uses protocol version %v (build %v) but this client uses version %v (build %v). Please use a matching version.", ProtocolVersion, Build, hello.ProtocolVersion, hello.Build)
+	} else if hello.Name == "" {
+		reply.Accepted = false
+		reply.Message = "A player name is required"
+	}
+	return reply
+}
+
+// SharedCapabilities returns the capabilities in both lists
+func SharedCapabilities(a, b []string) []string {
+	shared := []string{}
+	for _, c := range a {
+		for _, d := range b {
+			if c == d {
+				shared = append(shared, c)
+				break
+			}
+		}
+	}
+	return shared
+}
+
+// SendHello sends a hello over a stream and waits for the reply,
+// returning an error with the server's message if it is rejected
+func SendHello(stream io.ReadWriter, hello Hello) (HelloReply, error) {
+	var reply HelloReply
+	if err := gob.NewEncoder(stream).Encode(hello); err != nil {
+		return reply, err
+	}
+	if err := gob.NewDecoder(stream).Decode(&reply); err != nil {
+		return reply, err
+	}
+	if !reply.Accepted {
+		return reply, errors.New(reply.Message)
+	}
+	return reply, nil
+}
+
+// ReceiveHello reads a hello from a stream and sends the reply from check
+func ReceiveHello(stream io.ReadWriter, check func(Hello) HelloReply) (Hello, HelloReply, error) {
+	var hello Hello
+	if err := gob.NewDecoder(stream).Decode(&hello); err != nil {
+		return hello, HelloReply{}, err
+	}
+	reply := check(hello)
+	err := gob.NewEncoder(stream).Encode(reply)
+	return hello, reply, err
+}
@@ -0,0 +1,401 @@
+package common
+
+// MaxLight is the brightest light level a cell can have
+const (
+	MaxLight = 15
+)
+
+// MaterialLight is the block light emitted by each material
+var MaterialLight = []uint8{
+	0,        // air
+	0,        // grass
+	0,        // dirt
+	0,        // stone
+	0,        // moon
+	0,        // asteroid
+	MaxLight, // sun
+	0,        // blue_block
+	0,        // blue_sand
+	0,        // purple_block
+	0,        // purple_sand
+	0,        // red_block
+	0,        // red_sand
+	0,        // yellow_block
+	0,        // yellow_sand
+	0,        // water
+	0,        // blue_wood
+	0,        // chest
+	0,        // explosive
+}
+
+// IsTransparent returns whether light passes through a material
+func IsTransparent(material int) bool {
+	return material == Air
+}
+
+// lightSlot locates a cell inside a chunk that has light data
+type lightSlot struct {
+	chunk         *Chunk
+	ind           ChunkIndex
+	lon, lat, alt int
+}
+
+func (s lightSlot) offset() int {
+	return (s.lon*len(s.chunk.Cells[0])+s.lat)*ChunkSize + s.alt
+}
+
+func (s lightSlot) material() int {
+	return s.chunk.Cells[s.lon][s.lat][s.alt].Material
+}
+
+func (s lightSlot) sky() uint8 {
+	return s.chunk.light[s.offset()] >> 4
+}
+
+func (s lightSlot) block() uint8 {
+	return s.chunk.light[s.offset()] & 0xf
+}
+
+func (s lightSlot) setSky(v uint8) {
+	o := s.offset()
+	s.chunk.light[o] = v<<4 | s.chunk.light[o]&0xf
+}
+
+func (s lightSlot) setBlock(v uint8) {
+	o := s.offset()
+	s.chunk.light[o] = s.chunk.light[o]&0xf0 | v
+}
+
+// cellIndex returns the index of the first cell covered by the slot
+func (s lightSlot) cellIndex() CellIndex {
+	lonWidth := ChunkSize / len(s.chunk.Cells)
+	latWidth := ChunkSize / len(s.chunk.Cells[0])
+	return CellIndex{
+		Lon: ChunkSize*s.ind.Lon + s.lon*lonWidth,
+		Lat: ChunkSize*s.ind.Lat + s.lat*latWidth,
+		Alt: ChunkSize*s.ind.Alt + s.alt,
+	}
+}
+
+// Lit returns whether light levels have been computed for the chunk
+func (c *Chunk) Lit() bool {
+	return c.light != nil
+}
+
+// loadedChunk returns a chunk only if it is already loaded, without requesting it.
+// The caller must hold ChunksMutex.
+func (p *Planet) loadedChunk(ind ChunkIndex) *Chunk {
+	chunk := p.Chunks[ind]
+	if chunk == nil || chunk.WaitingForData {
+		return nil
+	}
+	return chunk
+}
+
+// wrapCellIndex wraps the longitude of a cell index and reports whether the index is on the planet
+func (p *Planet) wrapCellIndex(ind CellIndex) (CellIndex, bool) {
+	if ind.Lat < 0 || ind.Lat >= p.LatCells || ind.Alt < 0 || ind.Alt >= p.AltCells {
+		return ind, false
+	}
+	for ind.Lon < 0 {
+		ind.Lon += p.LonCells
+	}
+	ind.Lon %= p.LonCells
+	return ind, true
+}
+
+// loadedCell returns the cell at an index if its chunk is loaded.
+// The caller must hold ChunksMutex.
+func (p *Planet) loadedCell(ind CellIndex) *Cell {
+	ind, ok := p.wrapCellIndex(ind)
+	if !ok {
+		return nil
+	}
+	chunk := p.loadedChunk(p.CellIndexToChunkIndex(ind))
+	if chunk == nil {
+		return nil
+	}
+	lonWidth := ChunkSize / len(chunk.Cells)
+	latWidth := ChunkSize / len(chunk.Cells[0])
+	return chunk.Cells[(ind.Lon%ChunkSize)/lonWidth][(ind.Lat%ChunkSize)/latWidth][ind.Alt%ChunkSize]
+}
+
+// lightSlotAt returns the light slot for a cell index, or false if the cell is not in a lit chunk.
+// The caller must hold ChunksMutex.
+func (p *Planet) lightSlotAt(ind CellIndex) (lightSlot, bool) {
+	ind, ok := p.wrapCellIndex(ind)
+	if !ok {
+		return lightSlot{}, false
+	}
+	chunkInd := p.CellIndexToChunkIndex(ind)
+	chunk := p.loadedChunk(chunkInd)
+	if chunk == nil || chunk.light == nil {
+		return lightSlot{}, false
+	}
+	lonWidth := ChunkSize / len(chunk.Cells)
+	latWidth := ChunkSize / len(chunk.Cells[0])
+	return lightSlot{
+		chunk: chunk,
+		ind:   chunkInd,
+		lon:   (ind.Lon % ChunkSize) / lonWidth,
+		lat:   (ind.Lat % ChunkSize) / latWidth,
+		alt:   ind.Alt % ChunkSize,
+	}, true
+}
+
+// cellNeighbors returns the indices of the six cells sharing a face with a cell.
+// Cells may be wider than one index in longitude and latitude, so stepping in
+// the positive direction skips over the width of the cell.
+func (p *Planet) cellNeighbors(ind CellIndex) [6]CellIndex {
+	lonCells, latCells := p.LonLatCellsInChunkIndex(p.CellIndexToChunkIndex(ind))
+	lonWidth := ChunkSize / lonCells
+	latWidth := ChunkSize / latCells
+	ind.Lon = ind.Lon / lonWidth * lonWidth
+	ind.Lat = ind.Lat / latWidth * latWidth
+	return [6]CellIndex{
+		DirUp:     {Lon: ind.Lon, Lat: ind.Lat, Alt: ind.Alt + 1},
+		DirDown:   {Lon: ind.Lon, Lat: ind.Lat, Alt: ind.Alt - 1},
+		DirLonPos: {Lon: ind.Lon + lonWidth, Lat: ind.Lat, Alt: ind.Alt},
+		DirLonNeg: {Lon: ind.Lon - 1, Lat: ind.Lat, Alt: ind.Alt},
+		DirLatPos: {Lon: ind.Lon, Lat: ind.Lat + latWidth, Alt: ind.Alt},
+		DirLatNeg: {Lon: ind.Lon, Lat: ind.Lat - 1, Alt: ind.Alt},
+	}
+}
+
+// CellLight returns the sky and block light at a cell index.
+// Cells outside of lit chunks are treated as open sky.
+// The caller must hold ChunksMutex.
+func (p *Planet) CellLight(ind CellIndex) (sky, block uint8) {
+	s, ok := p.lightSlotAt(ind)
+	if !ok {
+		return MaxLight, 0
+	}
+	return s.sky(), s.block()
+}
+
+// TakeLightChanges returns the chunks whose light changed since the last call.
+// The caller must hold ChunksMutex.
+func (p *Planet) TakeLightChanges() []ChunkIndex {
+	changed := []ChunkIndex{}
+	for ind := range p.lightChanged {
+		changed = append(changed, ind)
+	}
+	p.lightChanged = make(map[ChunkIndex]bool)
+	return changed
+}
+
+// openToSky returns whether every cell from an index up to the top of the planet lets light through.
+// The caller must hold ChunksMutex.
+func (p *Planet) openToSky(ind CellIndex) bool {
+	for ind.Alt < p.AltCells {
+		if s, ok := p.lightSlotAt(ind); ok {
+			return IsTransparent(s.material()) && s.sky() == MaxLight
+		}
+		if c := p.loadedCell(ind); c != nil && !IsTransparent(c.Material) {
+			return false
+		}
+		ind.Alt++
+	}
+	return true
+}
+
+// UpdateChunkLight computes the light levels for a newly loaded chunk and spreads
+// light between it and its lit neighbors. Chunks are only lit once every chunk
+// above them is loaded, so sky light is known. Returns whether the chunk is lit.
+// The caller must hold ChunksMutex.
+func (p *Planet) UpdateChunkLight(ind ChunkIndex) bool {
+	chunk := p.loadedChunk(ind)
+	if chunk == nil {
+		return false
+	}
+	if chunk.light != nil {
+		return true
+	}
+	for alt := ind.Alt + 1; alt < p.AltCells/ChunkSize; alt++ {
+		if p.loadedChunk(ChunkIndex{Lon: ind.Lon, Lat: ind.Lat, Alt: alt}) == nil {
+			return false
+		}
+	}
+
+	lonCells := len(chunk.Cells)
+	latCells := len(chunk.Cells[0])
+	chunk.light = make([]uint8, lonCells*latCells*ChunkSize)
+
+	// Faces of adjacent chunks are lit by the cells of this chunk, so they need rebuilding as well
+	lonChunks := p.LonCells / ChunkSize
+	p.lightChanged[ind] = true
+	p.lightChanged[ChunkIndex{Lon: (ind.Lon + 1) % lonChunks, Lat: ind.Lat, Alt: ind.Alt}] = true
+	p.lightChanged[ChunkIndex{Lon: (ind.Lon + lonChunks - 1) % lonChunks, Lat: ind.Lat, Alt: ind.Alt}] = true
+	p.lightChanged[ChunkIndex{Lon: ind.Lon, Lat: ind.Lat + 1, Alt: ind.Alt}] = true
+	p.lightChanged[ChunkIndex{Lon: ind.Lon, Lat: ind.Lat - 1, Alt: ind.Alt}] = true
+	p.lightChanged[ChunkIndex{Lon: ind.Lon, Lat: ind.Lat, Alt: ind.Alt + 1}] = true
+	p.lightChanged[ChunkIndex{Lon: ind.Lon, Lat: ind.Lat, Alt: ind.Alt - 1}] = true
+
+	queue := []lightSlot{}
+	for lon := 0; lon < lonCells; lon++ {
+		for lat := 0; lat < latCells; lat++ {
+			// Sky light shines straight down until it reaches an opaque cell
+			top := lightSlot{chunk: chunk, ind: ind, lon: lon, lat: lat, alt: ChunkSize - 1}
+			sky := uint8(0)
+			if p.openToSky(p.cellNeighbors(top.cellIndex())[DirUp]) {
+				sky = MaxLight
+			}
+			for alt := ChunkSize - 1; alt >= 0; alt-- {
+				s := lightSlot{chunk: chunk, ind: ind, lon: lon, lat: lat, alt: alt}
+				m := s.material()
+				if !IsTransparent(m) {
+					sky = 0
+				}
+				s.setSky(sky)
+				s.setBlock(MaterialLight[m])
+				if sky > 0 || MaterialLight[m] > 0 {
+					queue = append(queue, s)
+				}
+			}
+		}
+	}
+
+	// Pull in light from neighboring chunks that are already lit
+	for lon := 0; lon < lonCells; lon++ {
+		for lat := 0; lat < latCells; lat++ {
+			for alt := 0; alt < ChunkSize; alt++ {
+				if lon != 0 && lon != lonCells-1 && lat != 0 && lat != latCells-1 && alt != 0 && alt != ChunkSize-1 {
+					continue
+				}
+				s := lightSlot{chunk: chunk, ind: ind, lon: lon, lat: lat, alt: alt}
+				for _, n := range p.cellNeighbors(s.cellIndex()) {
+					ns, ok := p.lightSlotAt(n)
+					if ok && ns.ind != ind && (ns.sky() > 0 || ns.block() > 0) {
+						queue = append(queue, ns)
+					}
+				}
+			}
+		}
+	}
+
+	p.propagateLight(queue)
+	return true
+}
+
+// propagateLight spreads light outward from a queue of lit cells into transparent neighbors
+func (p *Planet) propagateLight(queue []lightSlot) {
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		sky := s.sky()
+		block := s.block()
+		for dir, n := range p.cellNeighbors(s.cellIndex()) {
+			ns, ok := p.lightSlotAt(n)
+			if !ok || !IsTransparent(ns.material()) {
+				continue
+			}
+			changed := false
+			nsky := sky
+			if dir != DirDown || sky < MaxLight {
+				nsky = decrementLight(sky)
+			}
+			if nsky > ns.sky() {
+				ns.setSky(nsky)
+				changed = true
+			}
+			if nblock := decrementLight(block); nblock > ns.block() {
+				ns.setBlock(nblock)
+				changed = true
+			}
+			if changed {
+				p.lightChanged[ns.ind] = true
+				queue = append(queue, ns)
+			}
+		}
+	}
+}
+
+func decrementLight(v uint8) uint8 {
+	if v == 0 {
+		return 0
+	}
+	return v - 1
+}
+
+// updateCellLight updates light levels after the material of a cell changes.
+// Light that came from the old state of the cell is removed, then light from
+// the surrounding cells and any new emission is spread back in.
+// The caller must hold ChunksMutex.
+func (p *Planet) updateCellLight(ind CellIndex) {
+	s, ok := p.lightSlotAt(ind)
+	if !ok {
+		return
+	}
+	p.lightChanged[s.ind] = true
+	refill := []lightSlot{}
+
+	m := s.material()
+	oldSky := s.sky()
+	oldBlock := s.block()
+	s.setSky(0)
+	s.setBlock(MaterialLight[m])
+	refill = append(refill, p.removeLight(s, oldSky, true)...)
+	refill = append(refill, p.removeLight(s, oldBlock, false)...)
+
+	if IsTransparent(m) {
+		for _, n := range p.cellNeighbors(s.cellIndex()) {
+			if ns, ok := p.lightSlotAt(n); ok {
+				refill = append(refill, ns)
+			}
+		}
+		if _, ok := p.wrapCellIndex(p.cellNeighbors(s.cellIndex())[DirUp]); !ok {
+			s.setSky(MaxLight)
+		}
+	}
+	if s.sky() > 0 || s.block() > 0 {
+		refill = append(refill, s)
+	}
+	p.propagateLight(refill)
+}
+
+// removeLight darkens every cell whose light in one channel came through a cell
+// that previously had the given level. Returns the cells lit from elsewhere that
+// border the darkened region, which should be propagated again.
+func (p *Planet) removeLight(start lightSlot, level uint8, sky bool) []lightSlot {
+	get := lightSlot.block
+	set := lightSlot.setBlock
+	if sky {
+		get = lightSlot.sky
+		set = lightSlot.setSky
+	}
+	type removal struct {
+		slot  lightSlot
+		level uint8
+	}
+	refill := []lightSlot{}
+	queue := []removal{{start, level}}
+	for len(queue) > 0 {
+		r := queue[0]
+		queue = queue[1:]
+		if r.level == 0 {
+			continue
+		}
+		for dir, n := range p.cellNeighbors(r.slot.cellIndex()) {
+			ns, ok := p.lightSlotAt(n)
+			if !ok {
+				continue
+			}
+			nl := get(ns)
+			if nl == 0 {
+				continue
+			}
+			if nl < r.level || (sky && dir == DirDown && r.level == MaxLight && nl == MaxLight) {
+				set(ns, 0)
+				p.lightChanged[ns.ind] = true
+				if !sky && MaterialLight[ns.material()] > 0 {
+					set(ns, MaterialLight[ns.material()])
+					refill = append(refill, ns)
+				}
+				queue = append(queue, removal{ns, nl})
+			} else {
+				refill = append(refill, ns)
+			}
+		}
+	}
+	return refill
+}
@@ -0,0 +1,159 @@
+package common
+
+import "testing"
+
+// lightTestPlanet loads every chunk of a small stone planet, with the surface at altitude 16.
+// Cells with latitude 16 to 31 are in chunks away from the poles, so each cell has its own index.
+func lightTestPlanet() *Planet {
+	p := NewPlanet(PlanetState{ID: 0, Radius: 32, AltCells: 32}, nil, nil)
+	for lon := 0; lon < p.LonCells/ChunkSize; lon++ {
+		for lat := 0; lat < p.LatCells/ChunkSize; lat++ {
+			for alt := 0; alt < p.AltCells/ChunkSize; alt++ {
+				p.GetChunk(ChunkIndex{Lon: lon, Lat: lat, Alt: alt}, false)
+			}
+		}
+	}
+	return p
+}
+
+// lightAllChunks computes the light of every loaded chunk
+func lightAllChunks(t *testing.T, p *Planet) {
+	p.ChunksMutex.Lock()
+	defer p.ChunksMutex.Unlock()
+	for ind := range p.Chunks {
+		if !p.UpdateChunkLight(ind) {
+			t.Fatalf("chunk %v was not lit", ind)
+		}
+	}
+}
+
+// tunnel returns changes hollowing out cells along longitude, underground and away from the sky
+func tunnel(fromLon, toLon int) []CellChange {
+	changes := []CellChange{}
+	for lon := fromLon; lon <= toLon; lon++ {
+		changes = append(changes, CellChange{Index: CellIndex{Lon: lon, Lat: 24, Alt: 10}, Material: Air})
+	}
+	return changes
+}
+
+// shaft returns changes digging straight down from the surface
+func shaft() []CellChange {
+	changes := []CellChange{}
+	for alt := 15; alt >= 10; alt-- {
+		changes = append(changes, CellChange{Index: CellIndex{Lon: 8, Lat: 24, Alt: alt}, Material: Air})
+	}
+	return changes
+}
+
+func TestLight(t *testing.T) {
+	type level struct {
+		ind        CellIndex
+		sky, block uint8
+	}
+	sun := func(lon int) CellChange {
+		return CellChange{Index: CellIndex{Lon: lon, Lat: 24, Alt: 10}, Material: Sun}
+	}
+	air := func(lon int) CellChange {
+		return CellChange{Index: CellIndex{Lon: lon, Lat: 24, Alt: 10}, Material: Air}
+	}
+	capShaft := CellChange{Index: CellIndex{Lon: 8, Lat: 24, Alt: 16}, Material: Stone}
+	uncapShaft := CellChange{Index: CellIndex{Lon: 8, Lat: 24, Alt: 16}, Material: Air}
+	tests := []struct {
+		name string
+		// before are applied before the chunks are lit, after are applied one at a time once they are
+		before, after []CellChange
+		want          []level
+	}{
+		{
+			name: "open sky",
+			want: []level{
+				{CellIndex{Lon: 8, Lat: 24, Alt: 16}, MaxLight, 0},
+				{CellIndex{Lon: 8, Lat: 24, Alt: 24}, MaxLight, 0},
+				{CellIndex{Lon: 8, Lat: 24, Alt: 15}, 0, 0},
+			},
+		},
+		{
+			name:  "dug shaft",
+			after: shaft(),
+			want:  []level{{CellIndex{Lon: 8, Lat: 24, Alt: 12}, MaxLight, 0}},
+		},
+		{
+			name:  "covered shaft",
+			after: append(shaft(), capShaft),
+			want: []level{
+				{CellIndex{Lon: 8, Lat: 24, Alt: 15}, 0, 0},
+				{CellIndex{Lon: 8, Lat: 24, Alt: 12}, 0, 0},
+			},
+		},
+		{
+			name:  "uncovered shaft",
+			after: append(shaft(), capShaft, uncapShaft),
+			want:  []level{{CellIndex{Lon: 8, Lat: 24, Alt: 12}, MaxLight, 0}},
+		},
+		{
+			name:  "placed light",
+			after: append(tunnel(4, 8), sun(4)),
+			want: []level{
+				{CellIndex{Lon: 5, Lat: 24, Alt: 10}, 0, MaxLight - 1},
+				{CellIndex{Lon: 8, Lat: 24, Alt: 10}, 0, MaxLight - 4},
+			},
+		},
+		{
+			name:  "removed light",
+			after: append(tunnel(4, 8), sun(4), air(4)),
+			want: []level{
+				{CellIndex{Lon: 4, Lat: 24, Alt: 10}, 0, 0},
+				{CellIndex{Lon: 8, Lat: 24, Alt: 10}, 0, 0},
+			},
+		},
+		{
+			name:  "placed light across a chunk border",
+			after: append(tunnel(12, 18), sun(12)),
+			want: []level{
+				{CellIndex{Lon: 15, Lat: 24, Alt: 10}, 0, MaxLight - 3},
+				{CellIndex{Lon: 16, Lat: 24, Alt: 10}, 0, MaxLight - 4},
+				{CellIndex{Lon: 18, Lat: 24, Alt: 10}, 0, MaxLight - 6},
+			},
+		},
+		{
+			name:  "removed light across a chunk border",
+			after: append(tunnel(12, 18), sun(12), air(12)),
+			want: []level{
+				{CellIndex{Lon: 15, Lat: 24, Alt: 10}, 0, 0},
+				{CellIndex{Lon: 18, Lat: 24, Alt: 10}, 0, 0},
+			},
+		},
+		{
+			name:   "light shining into a chunk lit later",
+			before: append(tunnel(12, 18), sun(12)),
+			want: []level{
+				{CellIndex{Lon: 16, Lat: 24, Alt: 10}, 0, MaxLight - 4},
+				{CellIndex{Lon: 18, Lat: 24, Alt: 10}, 0, MaxLight - 6},
+			},
+		},
+		{
+			name:   "blocked sky before lighting",
+			before: append(shaft(), capShaft),
+			want:   []level{{CellIndex{Lon: 8, Lat: 24, Alt: 12}, 0, 0}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := lightTestPlanet()
+			for _, c := range test.before {
+				p.SetCellMaterial(c.Index, c.Material, false)
+			}
+			lightAllChunks(t, p)
+			for _, c := range test.after {
+				p.SetCellMaterial(c.Index, c.Material, false)
+			}
+			p.ChunksMutex.Lock()
+			defer p.ChunksMutex.Unlock()
+			for _, w := range test.want {
+				if sky, block := p.CellLight(w.ind); sky != w.sky || block != w.block {
+					t.Errorf("light at %v is sky %v block %v, expected sky %v block %v", w.ind, sky, block, w.sky, w.block)
+				}
+			}
+		})
+	}
+}
@@ -5,8 +5,8 @@ import (
 	"database/sql"
 	"encoding/gob"
 	"math"
-	"net/rpc"
 	"sync"
+	"time"
 
 	"github.com/go-gl/mathgl/mgl32"
 	opensimplex "github.com/ojrac/opensimplex-go"
@@ -33,13 +33,18 @@ type PlanetState struct {
 
 // Planet represents all the cells in a spherical planet
 type Planet struct {
-	rpc           *rpc.Client
+	rpc           RPCClient
 	db            *sql.DB
 	Geometry      *PlanetGeometry
 	GeometryMutex *sync.Mutex
 	Chunks        map[ChunkIndex]*Chunk
 	databaseMutex *sync.Mutex
 	ChunksMutex   *sync.Mutex
+	DeltaMutex    *sync.Mutex
+	lightChanged  map[ChunkIndex]bool
+	dirtyChunks   map[ChunkIndex]bool
+	chunkQueue    []ChunkIndex
+	chunkRequests int
 	noise         *opensimplex.Noise
 	Generator     func(*Planet, CellLoc) int
 	AltMin        float64
@@ -51,7 +56,7 @@ type Planet struct {
 }
 
 // NewPlanet constructs a Planet instance
-func NewPlanet(state PlanetState, crpc *rpc.Client, db *sql.DB) *Planet {
+func NewPlanet(state PlanetState, crpc RPCClient, db *sql.DB) *Planet {
 	p := Planet{}
 	p.PlanetState = state
 	p.noise = opensimplex.NewWithSeed(int64(p.Seed))
@@ -62,10 +67,13 @@ func NewPlanet(state PlanetState, crpc *rpc.Client, db *sql.DB) *Planet {
 	p.LonCells = int(2.0*math.Pi*3.0/4.0*(0.5*p.Radius)+0.5) / ChunkSize * ChunkSize
 	p.LatCells = int(p.LatMax/90.0*math.Pi*(0.5*p.Radius)) / ChunkSize * ChunkSize
 	p.Chunks = make(map[ChunkIndex]*Chunk)
+	p.lightChanged = make(map[ChunkIndex]bool)
+	p.dirtyChunks = make(map[ChunkIndex]bool)
 	p.rpc = crpc
 	p.db = db
 	p.databaseMutex = &sync.Mutex{}
 	p.ChunksMutex = &sync.Mutex{}
+	p.DeltaMutex = &sync.Mutex{}
 	p.GeometryMutex = &sync.Mutex{}
 	p.Generator = generators[p.GeneratorType]
 	if p.Generator == nil {
@@ -118,6 +126,7 @@ func (p *Planet) GetChunk(ind ChunkIndex, async bool) *Chunk {
 	if chunk == nil {
 		if p.rpc == nil {
 			if p.db != nil {
+				start := time.Now()
 				p.databaseMutex.Lock()
 				rows, e := p.db.Query("SELECT data FROM chunk WHERE planet = ? AND lon = ? AND lat = ? AND alt = ?", p.ID, ind.Lon, ind.Lat, ind.Alt)
 				if e != nil {
@@ -141,10 +150,12 @@ func (p *Planet) GetChunk(ind ChunkIndex, async bool) *Chunk {
 				}
 				rows.Close()
 				p.databaseMutex.Unlock()
-				if chunk == nil {
+				generated := chunk == nil
+				if generated {
 					chunk = newChunk(ind, p)
 					p.databaseMutex.Lock()
-					stmt, e := p.db.Prepare("INSERT INTO chunk VALUES (?, ?, ?, ?, ?)")
+					// Another caller may have generated the chunk meanwhile, and its copy is kept below
+					stmt, e := p.db.Prepare("INSERT OR IGNORE INTO chunk VALUES (?, ?, ?, ?, ?)")
 					if e != nil {
 						panic(e)
 					}
@@ -160,8 +171,15 @@ func (p *Planet) GetChunk(ind ChunkIndex, async bool) *Chunk {
 					}
 					p.databaseMutex.Unlock()
 				}
+				if ChunkLoaded != nil {
+					ChunkLoaded(generated, time.Since(start))
+				}
 				p.ChunksMutex.Lock()
-				p.Chunks[ind] = chunk
+				if loaded := p.Chunks[ind]; loaded != nil {
+					chunk = loaded
+				} else {
+					p.Chunks[ind] = chunk
+				}
 				p.ChunksMutex.Unlock()
 			} else {
 				chunk = newChunk(ind, p)
@@ -170,26 +188,11 @@ func (p *Planet) GetChunk(ind ChunkIndex, async bool) *Chunk {
 				p.ChunksMutex.Unlock()
 			}
 		} else {
-			rchunk := Chunk{}
-			pind := PlanetChunkIndex{Planet: p.ID, ChunkIndex: ind}
-			if async {
-				call := p.rpc.Go("API.GetChunk", pind, &rchunk, nil)
-				go func() {
-					call = <-call.Done
-					p.ChunksMutex.Lock()
-					p.Chunks[ind] = &rchunk
-					p.ChunksMutex.Unlock()
-				}()
-				p.ChunksMutex.Lock()
-				p.Chunks[ind] = &Chunk{WaitingForData: true}
-				p.ChunksMutex.Unlock()
-			} else {
-				e := p.rpc.Call("API.GetChunk", pind, &rchunk)
-				if e != nil {
-					panic(e)
-				}
+			p.subscribeChunk(ind)
+			if !async {
+				p.SendChunkRequests(true)
 				p.ChunksMutex.Lock()
-				p.Chunks[ind] = &rchunk
+				chunk = p.Chunks[ind]
 				p.ChunksMutex.Unlock()
 			}
 		}
@@ -202,48 +205,111 @@ type RPCSetCellMaterialArgs struct {
 	Planet   int
 	Index    CellIndex
 	Material int
+	State    CellState
 }
 
-// SetCellMaterial sets the material for a cell
+// SetCellMaterial sets the material for a cell, clearing its state
 func (p *Planet) SetCellMaterial(ind CellIndex, material int, updateServer bool) bool {
+	return p.SetCellMaterialState(ind, material, 0, updateServer)
+}
+
+// SetCellMaterialState sets the material and block state for a cell
+func (p *Planet) SetCellMaterialState(ind CellIndex, material int, state CellState, updateServer bool) bool {
 	cell := p.CellIndexToCell(ind)
 	if cell == nil {
 		return false
 	}
-	if cell.Material == material {
+	if cell.Material == material && cell.State == state {
 		return false
 	}
 	cell.Material = material
+	cell.State = state
+	p.ChunksMutex.Lock()
+	p.updateCellLight(ind)
+	p.ChunksMutex.Unlock()
 	if p.rpc != nil && updateServer {
 		var ret bool
 		p.rpc.Go("API.SetCellMaterial", RPCSetCellMaterialArgs{
 			Planet:   p.ID,
 			Index:    ind,
 			Material: material,
+			State:    state,
 		}, &ret, nil)
 	}
 	if p.db != nil {
-		chunkInd := p.CellIndexToChunkIndex(ind)
-		chunk := p.CellIndexToChunk(ind)
-		p.databaseMutex.Lock()
-		stmt, e := p.db.Prepare("UPDATE chunk SET data = ? WHERE planet = 0 AND lon = ? AND lat = ? AND alt = ?")
-		if e != nil {
-			panic(e)
-		}
-		var buf bytes.Buffer
-		enc := gob.NewEncoder(&buf)
-		e = enc.Encode(chunk)
-		if e != nil {
-			panic(e)
+		p.markDirty(p.CellIndexToChunkIndex(ind))
+	}
+
+	return true
+}
+
+// SetCellMaterials applies many cell changes at once, marking each changed chunk to be saved.
+// It returns the changes that modified a cell.
+func (p *Planet) SetCellMaterials(changes []CellChange) []CellChange {
+	var applied []CellChange
+	changedChunks := make(map[ChunkIndex]bool)
+	for _, change := range changes {
+		cell := p.CellIndexToCell(change.Index)
+		if cell == nil || (cell.Material == change.Material && cell.State == change.State) {
+			continue
 		}
-		_, e = stmt.Exec(buf.Bytes(), chunkInd.Lon, chunkInd.Lat, chunkInd.Alt)
-		if e != nil {
-			panic(e)
+		cell.Material = change.Material
+		cell.State = change.State
+		p.ChunksMutex.Lock()
+		p.updateCellLight(change.Index)
+		p.ChunksMutex.Unlock()
+		changedChunks[p.CellIndexToChunkIndex(change.Index)] = true
+		applied = append(applied, change)
+	}
+	if p.db != nil {
+		for chunkInd := range changedChunks {
+			p.markDirty(chunkInd)
 		}
-		p.databaseMutex.Unlock()
 	}
+	return applied
+}
 
-	return true
+// markDirty records that a chunk changed since it was last saved
+func (p *Planet) markDirty(chunkInd ChunkIndex) {
+	p.databaseMutex.Lock()
+	p.dirtyChunks[chunkInd] = true
+	p.databaseMutex.Unlock()
+}
+
+// SaveDirtyChunks writes the chunks changed since they were last saved to the database
+func (p *Planet) SaveDirtyChunks() {
+	p.databaseMutex.Lock()
+	dirty := p.dirtyChunks
+	p.dirtyChunks = make(map[ChunkIndex]bool)
+	p.databaseMutex.Unlock()
+	for chunkInd := range dirty {
+		p.saveChunk(chunkInd)
+	}
+}
+
+// saveChunk writes a chunk to the database
+func (p *Planet) saveChunk(chunkInd ChunkIndex) {
+	chunk := p.GetChunk(chunkInd, true)
+	p.databaseMutex.Lock()
+	start := time.Now()
+	stmt, e := p.db.Prepare("UPDATE chunk SET data = ? WHERE planet = ? AND lon = ? AND lat = ? AND alt = ?")
+	if e != nil {
+		panic(e)
+	}
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	e = enc.Encode(chunk)
+	if e != nil {
+		panic(e)
+	}
+	_, e = stmt.Exec(buf.Bytes(), p.ID, chunkInd.Lon, chunkInd.Lat, chunkInd.Alt)
+	if e != nil {
+		panic(e)
+	}
+	if DatabaseWrite != nil {
+		DatabaseWrite(time.Since(start))
+	}
+	p.databaseMutex.Unlock()
 }
 
 func (p *Planet) validateCellLoc(l CellLoc) CellLoc {
@@ -338,6 +404,13 @@ func (p *Planet) CellLocToCell(l CellLoc) *Cell {
 	return p.CellIndexToCell(p.CellLocToCellIndex(l))
 }
 
+// ValidCellIndex returns whether a cell index lies within the planet
+func (p *Planet) ValidCellIndex(ind CellIndex) bool {
+	return ind.Lon >= 0 && ind.Lon < p.LonCells &&
+		ind.Lat >= 0 && ind.Lat < p.LatCells &&
+		ind.Alt >= 0 && ind.Alt < p.AltCells
+}
+
 // CellIndexToCell converts a cell index to a cell
 func (p *Planet) CellIndexToCell(cellIndex CellIndex) *Cell {
 	chunkIndex := p.CellIndexToChunkIndex(cellIndex)
@@ -431,6 +504,16 @@ func (p *Planet) LonLatCellsInChunkIndex(ind ChunkIndex) (lonCells, latCells int
 type Chunk struct {
 	WaitingForData bool
 	Cells          [][][]*Cell
+
+	// Seq is the number of the last delta applied to the chunk
+	Seq uint64
+
+	// Deltas that arrived while waiting for the chunk's data
+	pending []*ChunkDelta
+
+	// Sky light in the high four bits and block light in the low four bits,
+	// computed locally and never sent over the network or saved
+	light []uint8
 }
 
 func newChunk(ind ChunkIndex, p *Planet) *Chunk {
@@ -467,6 +550,7 @@ func newChunk(ind ChunkIndex, p *Planet) *Chunk {
 // Cell is a single block on a planet
 type Cell struct {
 	Material int
+	State    CellState
 }
 
 type stringSlice []string
@@ -499,6 +583,9 @@ var (
 		"yellow_block",
 		"yellow_sand",
 		"water",
+		"blue_wood",
+		"chest",
+		"explosive",
 	}
 	MaterialColors = []mgl32.Vec3{
 		{0.0, 0.0, 0.0},
@@ -517,6 +604,30 @@ var (
 		{1.0, 1.0, 0.0},
 		{1.0, 1.0, 0.0},
 		{0.0, 0.0, 0.0},
+		{0.3, 0.3, 0.8},
+		{0.6, 0.4, 0.2},
+		{0.8, 0.15, 0.1},
+	}
+	MaterialHardness = []float32{
+		0.0,
+		0.6,
+		0.5,
+		1.5,
+		1.2,
+		2.0,
+		3.0,
+		1.5,
+		0.5,
+		1.5,
+		0.5,
+		1.5,
+		0.5,
+		1.5,
+		0.5,
+		0.0,
+		1.0,
+		1.0,
+		0.5,
 	}
 	Air         = Materials.pos("air")
 	Grass       = Materials.pos("grass")
@@ -534,6 +645,9 @@ var (
 	YellowBlock = Materials.pos("yellow_block")
 	YellowSand  = Materials.pos("yellow_sand")
 	Water       = Materials.pos("water")
+	BlueWood    = Materials.pos("blue_wood")
+	Chest       = Materials.pos("chest")
+	Explosive   = Materials.pos("explosive")
 )
 
 // PlanetGeometry holds the low-resolution geometry for a planet.
@@ -584,7 +698,12 @@ func (p *Planet) GetGeometry(async bool) *PlanetGeometry {
 			go func() {
 				call = <-call.Done
 				p.GeometryMutex.Lock()
-				p.Geometry = &geom
+				if call.Error != nil {
+					// Ask again next time
+					p.Geometry = nil
+				} else {
+					p.Geometry = &geom
+				}
 				p.GeometryMutex.Unlock()
 			}()
 			p.GeometryMutex.Lock()
@@ -47,10 +47,17 @@ type Player struct {
 	Name             string
 	ActiveHotBarSlot int
 	FocusCellIndex   CellIndex
+	focused          bool
+	HoldingDestroy   bool
+	MiningCellIndex  CellIndex
+	MiningSeconds    float32
+	MiningProgress   float32
 	HotbarOn         bool
 	Hotbar           [12]Slot
 	Inventory        [48]Slot
+	OpenEntity       *BlockEntity
 	renderDistance   int
+	chunksPlanet     *Planet
 	Health           int
 	Text             string
 	DrawText         string
@@ -63,6 +70,14 @@ type Slot struct {
 	Amount   int
 }
 
+// TeleportArgs are the arguments for the Teleport API call, which moves a player
+// to a position on a planet or to the planet's spawn
+type TeleportArgs struct {
+	Planet   int
+	Position mgl32.Vec3
+	Spawn    bool
+}
+
 // HitPlayerArgs are the arguments for the HitPlayer API call
 type HitPlayerArgs struct {
 	From   string
@@ -70,6 +85,13 @@ type HitPlayerArgs struct {
 	Amount int
 }
 
+// BreakCellArgs are the arguments for the StartMining and BreakCell API calls
+type BreakCellArgs struct {
+	From string
+	PlanetCellIndex
+	Seconds float32
+}
+
 // NewPlayer creates a new player
 func NewPlayer(name string) *Player {
 	p := Player{}
@@ -138,6 +160,98 @@ func (player *Player) UpdateHealth(amount int) {
 	}
 }
 
+// AddItems adds items to the player's inventory, filling partial stacks of the same material first.
+// Items that do not fit are lost.
+func (player *Player) AddItems(items []Slot) {
+	for _, item := range items {
+		if item.Amount <= 0 {
+			continue
+		}
+		placed := false
+		for i := range player.Inventory {
+			if player.Inventory[i].Amount > 0 && player.Inventory[i].Material == item.Material {
+				player.Inventory[i].Amount += item.Amount
+				placed = true
+				break
+			}
+		}
+		for i := 0; i < len(player.Inventory) && !placed; i++ {
+			if player.Inventory[i].Amount == 0 {
+				player.Inventory[i] = item
+				placed = true
+			}
+		}
+	}
+}
+
+// SetItems makes the player's hotbar and inventory hold the given total of each material,
+// as the server counts them. Items stay in the slots they are in, removing from the last slots
+// first, and any more are added to the inventory.
+func (player *Player) SetItems(items []Slot) {
+	want := make(map[int]int)
+	for _, item := range items {
+		want[item.Material] += item.Amount
+	}
+	slots := make([]*Slot, 0, len(player.Hotbar)+len(player.Inventory))
+	for i := range player.Hotbar {
+		slots = append(slots, &player.Hotbar[i])
+	}
+	for i := range player.Inventory {
+		slots = append(slots, &player.Inventory[i])
+	}
+	for _, s := range slots {
+		if s.Amount > want[s.Material] {
+			s.Amount = want[s.Material]
+		}
+		if s.Amount <= 0 {
+			*s = Slot{}
+			continue
+		}
+		want[s.Material] -= s.Amount
+	}
+	for _, item := range items {
+		if want[item.Material] > 0 {
+			player.AddItems([]Slot{{Material: item.Material, Amount: want[item.Material]}})
+			want[item.Material] = 0
+		}
+	}
+}
+
+// UpdateMining advances mining of the focused cell while the destroy key is held,
+// starting over whenever the focused cell changes. It returns whether mining of a
+// new cell started and whether the cell has been mined long enough to break.
+func (player *Player) UpdateMining(h float32) (started, broken bool) {
+	cell := player.Planet.CellIndexToCell(player.FocusCellIndex)
+	if !player.HoldingDestroy || !player.focused || cell == nil || cell.Material == Air {
+		player.MiningSeconds = 0
+		player.MiningProgress = 0
+		return false, false
+	}
+	if player.MiningSeconds == 0 || player.MiningCellIndex != player.FocusCellIndex {
+		player.MiningCellIndex = player.FocusCellIndex
+		player.MiningSeconds = 0
+		started = true
+	}
+	player.MiningSeconds += h
+	hardness := MaterialHardness[cell.Material]
+	player.MiningProgress = 1
+	if hardness > 0 {
+		player.MiningProgress = float32(math.Min(float64(player.MiningSeconds/hardness), 1))
+	}
+	if player.MiningProgress >= 1 {
+		player.MiningSeconds = 0
+		broken = true
+	}
+	return started, broken
+}
+
+// ResetMining stops mining and clears any mining progress
+func (player *Player) ResetMining() {
+	player.HoldingDestroy = false
+	player.MiningSeconds = 0
+	player.MiningProgress = 0
+}
+
 // LookDir returns the player's look direction
 func (player *Player) LookDir() mgl32.Vec3 {
 	up := player.Location().Normalize()
@@ -173,16 +287,58 @@ func (player *Player) LoadNearbyChunks(async bool) {
 		latMax := Min(ind.Lat+player.renderDistance, planet.LatCells/ChunkSize-1)
 		for lat := latMin; lat <= latMax; lat++ {
 			for alt := 0; alt < planet.AltCells/ChunkSize; alt++ {
-				planet.GetChunk(ChunkIndex{Lon: validLon, Lat: lat, Alt: alt}, async)
+				planet.GetChunk(ChunkIndex{Lon: validLon, Lat: lat, Alt: alt}, true)
 			}
 		}
 	}
+	planet.SendChunkRequests(!async)
+}
+
+// unloadChunkMargin is how many chunks beyond the render distance are kept before being unloaded
+const unloadChunkMargin = 2
+
+// unloadFarChunks unloads the chunks the player has moved away from,
+// and every chunk of the planet the player was on if they changed planets
+func (player *Player) unloadFarChunks() {
+	planet := player.Planet
+	if player.chunksPlanet != nil && player.chunksPlanet != planet {
+		old := player.chunksPlanet
+		old.ChunksMutex.Lock()
+		keys := []ChunkIndex{}
+		for key := range old.Chunks {
+			keys = append(keys, key)
+		}
+		old.ChunksMutex.Unlock()
+		for _, key := range keys {
+			old.UnloadChunk(key)
+		}
+	}
+	player.chunksPlanet = planet
+
+	up := player.Location().Normalize()
+	feet := player.Location().Sub(up.Mul(float32(player.height)))
+	ind := planet.CartesianToChunkIndex(feet)
+	lonChunks := planet.LonCells / ChunkSize
+	far := []ChunkIndex{}
+	planet.ChunksMutex.Lock()
+	for key := range planet.Chunks {
+		lonDist := Abs(key.Lon - ind.Lon)
+		lonDist = Min(lonDist, lonChunks-lonDist)
+		if lonDist > player.renderDistance+unloadChunkMargin || Abs(key.Lat-ind.Lat) > player.renderDistance+unloadChunkMargin {
+			far = append(far, key)
+		}
+	}
+	planet.ChunksMutex.Unlock()
+	for _, key := range far {
+		planet.UnloadChunk(key)
+	}
 }
 
 // UpdatePosition updates the player position
 func (player *Player) UpdatePosition(h float32) {
 	planet := player.Planet
 	player.LoadNearbyChunks(true)
+	player.unloadFarChunks()
 	if h > 0.05 {
 		h = 0.05
 	}
@@ -227,12 +383,14 @@ func (player *Player) UpdatePosition(h float32) {
 	increment := player.LookDir().Mul(0.05)
 	pos := player.Location()
 	player.FocusCellIndex = CellIndex{Lat: 0, Lon: 0, Alt: 0}
+	player.focused = false
 	for i := 0; i < 100; i++ {
 		pos = pos.Add(increment)
 		cell := planet.CartesianToCell(pos)
 		if cell != nil && cell.Material != Air {
 			cellIndex := planet.CartesianToCellIndex(pos)
 			player.FocusCellIndex = cellIndex
+			player.focused = true
 			break
 		}
 	}
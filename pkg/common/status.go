@@ -0,0 +1,57 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/hashicorp/yamux"
+)
+
+// StatusTimeout is how long a status query may take, kept short so unreachable servers are shown quickly
+const StatusTimeout = 5 * time.Second
+
+// Status is what a server tells anyone who asks, without them joining
+type Status struct {
+	MOTD            string
+	Name            string
+	World           string
+	ProtocolVersion int
+	Build           string
+	Players         []string
+	Planets         int
+	Uptime          time.Duration
+}
+
+// NewStatusHello creates the hello that asks a server for its status
+func NewStatusHello() Hello {
+	hello := NewHello("", "")
+	hello.StatusOnly = true
+	return hello
+}
+
+// QueryStatus asks for a server's status over a connection without joining, returning it along with
+// how long the server took to answer. The connection is closed when it returns.
+func QueryStatus(conn net.Conn) (*Status, time.Duration, error) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(StatusTimeout))
+	mux, err := yamux.Client(conn, MuxConfig())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer mux.Close()
+	stream, err := mux.Open()
+	if err != nil {
+		return nil, 0, err
+	}
+	start := time.Now()
+	reply, err := SendHello(stream, NewStatusHello())
+	latency := time.Since(start)
+	if err != nil {
+		return nil, latency, err
+	}
+	if reply.Status == nil {
+		return nil, latency, errors.New("Server did not send its status")
+	}
+	return reply.Status, latency, nil
+}
@@ -0,0 +1,16 @@
+package common
+
+import "time"
+
+// Timing hooks let a server or a tool measure work done in this package. They are nil unless set,
+// and must be set before any planets are used.
+var (
+	// ChunkLoaded is called with how long a chunk took to read from the database, or to generate and store if it was new
+	ChunkLoaded func(generated bool, elapsed time.Duration)
+
+	// DatabaseWrite is called with how long a chunk took to write to the database
+	DatabaseWrite func(elapsed time.Duration)
+
+	// ChunksReceived is called on a client with how long the server took to answer a batch of chunk requests
+	ChunksReceived func(chunks int, elapsed time.Duration)
+)
@@ -4,15 +4,18 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/gob"
+	"sync"
 
 	opensimplex "github.com/ojrac/opensimplex-go"
 )
 
 // Universe stores the set of planets in a universe
 type Universe struct {
-	seed      int
-	noise     *opensimplex.Noise
-	PlanetMap map[int]*Planet
+	seed       int
+	noise      *opensimplex.Noise
+	PlanetMap  map[int]*Planet
+	clock      float64
+	clockMutex *sync.Mutex
 }
 
 // NewUniverse creates a universe with a given seed
@@ -20,6 +23,7 @@ func NewUniverse(db *sql.DB, systemType string) *Universe {
 	u := Universe{}
 	u.noise = opensimplex.NewWithSeed(0)
 	u.PlanetMap = make(map[int]*Planet)
+	u.clockMutex = &sync.Mutex{}
 	planetStates := queryPlanetStates(db)
 
 	// If no planets in the database, generate a planetary system
@@ -43,6 +47,27 @@ func NewUniverse(db *sql.DB, systemType string) *Universe {
 	return &u
 }
 
+// Time returns the universe clock in seconds, which drives planet rotations and orbits
+func (u *Universe) Time() float64 {
+	u.clockMutex.Lock()
+	defer u.clockMutex.Unlock()
+	return u.clock
+}
+
+// SetTime sets the universe clock
+func (u *Universe) SetTime(seconds float64) {
+	u.clockMutex.Lock()
+	u.clock = seconds
+	u.clockMutex.Unlock()
+}
+
+// AdvanceTime moves the universe clock forward
+func (u *Universe) AdvanceTime(seconds float64) {
+	u.clockMutex.Lock()
+	u.clock += seconds
+	u.clockMutex.Unlock()
+}
+
 func queryPlanetStates(db *sql.DB) []*PlanetState {
 	states := []*PlanetState{}
 	rows, err := db.Query("SELECT data FROM planet")
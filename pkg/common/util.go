@@ -32,3 +32,11 @@ func Max(val, a int) int {
 	}
 	return a
 }
+
+// Abs returns the absolute value of an integer
+func Abs(val int) int {
+	if val < 0 {
+		return -val
+	}
+	return val
+}
@@ -46,6 +46,25 @@ func (screen *Screen) Clear() {
 	screen.labels = nil
 }
 
+// Layout is the elements on a screen, kept to put back after showing something else
+type Layout struct {
+	labels  []*Label
+	buttons []*Button
+	entrys  []*Entry
+}
+
+// Layout returns the elements on the screen
+func (screen *Screen) Layout() Layout {
+	return Layout{labels: screen.labels, buttons: screen.buttons, entrys: screen.entrys}
+}
+
+// SetLayout replaces the elements on the screen
+func (screen *Screen) SetLayout(layout Layout) {
+	screen.labels = layout.labels
+	screen.buttons = layout.buttons
+	screen.entrys = layout.entrys
+}
+
 // NewScreen creates a new GUI screen associated with a GLFW window
 func NewScreen(window *glfw.Window) *Screen {
 	s := Screen{}
@@ -115,7 +134,7 @@ func (screen *Screen) MouseButtonCallback() func(w *glfw.Window, button glfw.Mou
 		wd, ht := w.GetSize()
 		x, y := screen.Xpos/float64(wd)*2-1, -(screen.Ypos/float64(ht)*2 - 1)
 		for _, b := range screen.buttons {
-			if b.isInside(x, y) && button == glfw.MouseButtonLeft && action == glfw.Press {
+			if !b.Hide && b.isInside(x, y) && button == glfw.MouseButtonLeft && action == glfw.Press {
 				b.Command()
 			}
 		}
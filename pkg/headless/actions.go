@@ -0,0 +1,87 @@
+package headless
+
+import (
+	"net/rpc"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// Actions are sent without waiting for the server. Each returns the pending call,
+// which reports the server's answer on its Done channel or through Wait.
+
+// SendState sends the player's position and look direction to the server
+func (c *Client) SendState() *rpc.Call {
+	var ret bool
+	return c.Go("API.UpdatePersonState", &common.PlayerState{
+		Name:     c.Name,
+		Planet:   c.Player.Planet.ID,
+		Position: c.Player.Location(),
+		LookDir:  c.Player.LookDir(),
+	}, &ret, nil)
+}
+
+// SetCellMaterial places a material in a cell
+func (c *Client) SetCellMaterial(ind common.PlanetCellIndex, material int, state common.CellState) *rpc.Call {
+	var ret bool
+	return c.Go("API.SetCellMaterial", common.RPCSetCellMaterialArgs{
+		Planet:   ind.Planet,
+		Index:    ind.CellIndex,
+		Material: material,
+		State:    state,
+	}, &ret, nil)
+}
+
+// StartMining tells the server the player started mining a cell
+func (c *Client) StartMining(ind common.PlanetCellIndex) *rpc.Call {
+	var ret bool
+	return c.Go("API.StartMining", common.BreakCellArgs{From: c.Name, PlanetCellIndex: ind}, &ret, nil)
+}
+
+// BreakCell breaks a cell that has been mined for some seconds, storing what it dropped in contents
+func (c *Client) BreakCell(ind common.PlanetCellIndex, seconds float32, contents *[]common.Slot) *rpc.Call {
+	args := common.BreakCellArgs{
+		From:            c.Name,
+		PlanetCellIndex: ind,
+		Seconds:         seconds,
+	}
+	return c.Go("API.BreakCell", args, contents, nil)
+}
+
+// TriggerCell triggers a cell, such as lighting an explosive
+func (c *Client) TriggerCell(ind common.PlanetCellIndex) *rpc.Call {
+	var ret bool
+	return c.Go("API.TriggerCell", common.TriggerArgs{From: c.Name, PlanetCellIndex: ind}, &ret, nil)
+}
+
+// OpenBlockEntity opens the block entity at a cell, storing its contents in entity
+func (c *Client) OpenBlockEntity(ind common.PlanetCellIndex, entity *common.BlockEntity) *rpc.Call {
+	return c.Go("API.OpenBlockEntity", common.BlockEntityArgs{From: c.Name, PlanetCellIndex: ind}, entity, nil)
+}
+
+// CloseBlockEntity tells the server the player is no longer viewing a block entity
+func (c *Client) CloseBlockEntity(ind common.PlanetCellIndex) *rpc.Call {
+	var ret bool
+	return c.Go("API.CloseBlockEntity", common.BlockEntityArgs{From: c.Name, PlanetCellIndex: ind}, &ret, nil)
+}
+
+// SetBlockEntitySlot puts contents in one slot of an open block entity
+func (c *Client) SetBlockEntitySlot(ind common.PlanetCellIndex, slot int, contents common.Slot) *rpc.Call {
+	var ret bool
+	return c.Go("API.SetBlockEntitySlot", common.BlockEntitySlotArgs{
+		BlockEntityArgs: common.BlockEntityArgs{From: c.Name, PlanetCellIndex: ind},
+		Slot:            slot,
+		Contents:        contents,
+	}, &ret, nil)
+}
+
+// HitPlayer hits another player
+func (c *Client) HitPlayer(target string, amount int) *rpc.Call {
+	var ret bool
+	return c.Go("API.HitPlayer", common.HitPlayerArgs{From: c.Name, Target: target, Amount: amount}, &ret, nil)
+}
+
+// Chat sends a chat message, or a slash command
+func (c *Client) Chat(text string) *rpc.Call {
+	var ret bool
+	return c.Go("API.Chat", text, &ret, nil)
+}
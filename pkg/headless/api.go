@@ -0,0 +1,171 @@
+package headless
+
+import (
+	"errors"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// clientAPI answers the calls the server makes on a client
+type clientAPI struct {
+	c *Client
+}
+
+// ChunkDelta applies the changes to a chunk the player has loaded
+func (api *clientAPI) ChunkDelta(delta *common.ChunkDelta, ret *bool) error {
+	*ret = true
+	planet := api.c.Planets[delta.Planet]
+	if planet == nil {
+		return nil
+	}
+	applied := planet.ApplyChunkDelta(delta)
+	if h := api.c.Handlers.ChunkDelta; h != nil && len(applied) > 0 {
+		h(delta.Planet, applied)
+	}
+	return nil
+}
+
+// PersonDisconnected forgets a player who has disconnected or is out of view
+func (api *clientAPI) PersonDisconnected(name *string, ret *bool) error {
+	api.c.mutex.Lock()
+	_, *ret = api.c.people[*name]
+	delete(api.c.people, *name)
+	api.c.mutex.Unlock()
+	if h := api.c.Handlers.PersonDisconnected; h != nil {
+		h(*name)
+	}
+	return nil
+}
+
+// UpdatePersonState updates another person's state
+func (api *clientAPI) UpdatePersonState(state *common.PlayerState, ret *bool) error {
+	if state.Name == api.c.Name {
+		return nil
+	}
+	api.c.mutex.Lock()
+	api.c.people[state.Name] = state
+	api.c.mutex.Unlock()
+	if h := api.c.Handlers.PersonState; h != nil {
+		h(state)
+	}
+	*ret = true
+	return nil
+}
+
+// Chat receives a chat message
+func (api *clientAPI) Chat(msg *common.ChatMessage, ret *bool) error {
+	api.c.mutex.Lock()
+	if msg.Time.After(api.c.lastChat) {
+		api.c.lastChat = msg.Time
+	}
+	api.c.mutex.Unlock()
+	if h := api.c.Handlers.Chat; h != nil {
+		h(*msg)
+	}
+	*ret = true
+	return nil
+}
+
+// ChatHistory receives the messages sent before the player joined. After reconnecting,
+// only the messages sent while the client was away are new to it.
+func (api *clientAPI) ChatHistory(msgs *[]common.ChatMessage, ret *bool) error {
+	api.c.mutex.Lock()
+	missed := []common.ChatMessage{}
+	for _, msg := range *msgs {
+		if msg.Time.After(api.c.lastChat) {
+			missed = append(missed, msg)
+		}
+	}
+	if len(missed) > 0 {
+		api.c.lastChat = missed[len(missed)-1].Time
+	}
+	api.c.mutex.Unlock()
+	if h := api.c.Handlers.ChatHistory; h != nil && len(missed) > 0 {
+		h(missed)
+	}
+	*ret = true
+	return nil
+}
+
+// Disconnect stores why the server is about to disconnect the player
+func (api *clientAPI) Disconnect(reason *string, ret *bool) error {
+	api.c.mutex.Lock()
+	api.c.disconnectReason = *reason
+	api.c.mutex.Unlock()
+	if h := api.c.Handlers.Disconnect; h != nil {
+		h(*reason)
+	}
+	*ret = true
+	return nil
+}
+
+// Teleport moves the player to a position on a planet, or to the planet's spawn
+func (api *clientAPI) Teleport(args *common.TeleportArgs, ret *bool) error {
+	planet := api.c.Planets[args.Planet]
+	if planet == nil {
+		return errors.New("Unknown planet ID")
+	}
+	player := api.c.Player
+	player.Planet = planet
+	if args.Spawn {
+		player.Spawn()
+	} else {
+		player.SetLocation(args.Position)
+		player.LoadNearbyChunks(true)
+	}
+	if h := api.c.Handlers.Teleport; h != nil {
+		h(*args)
+	}
+	*ret = true
+	return nil
+}
+
+// SetGameMode switches the player between creative and survival at the next update
+func (api *clientAPI) SetGameMode(mode *int, ret *bool) error {
+	api.c.mutex.Lock()
+	api.c.gameMode = *mode
+	api.c.gameModeChanged = true
+	api.c.mutex.Unlock()
+	if h := api.c.Handlers.GameMode; h != nil {
+		h(*mode)
+	}
+	*ret = true
+	return nil
+}
+
+// SetInventory sets the items the player has, as the server counts them, for the next update to apply
+func (api *clientAPI) SetInventory(items *[]common.Slot, ret *bool) error {
+	api.c.mutex.Lock()
+	api.c.inventory = *items
+	api.c.inventoryChanged = true
+	api.c.mutex.Unlock()
+	if h := api.c.Handlers.Inventory; h != nil {
+		h(*items)
+	}
+	*ret = true
+	return nil
+}
+
+// HitPlayer damages the player
+func (api *clientAPI) HitPlayer(args *common.HitPlayerArgs, ret *bool) error {
+	api.c.Player.UpdateHealth(-args.Amount)
+	if h := api.c.Handlers.Hit; h != nil {
+		h(*args)
+	}
+	*ret = true
+	return nil
+}
+
+// UpdateBlockEntity updates the contents of the block entity the player has open
+func (api *clientAPI) UpdateBlockEntity(entity *common.BlockEntity, ret *bool) error {
+	player := api.c.Player
+	open := player.OpenEntity
+	if open != nil && open.PlanetCellIndex == entity.PlanetCellIndex {
+		player.OpenEntity = entity
+	}
+	if h := api.c.Handlers.BlockEntity; h != nil {
+		h(entity)
+	}
+	*ret = true
+	return nil
+}
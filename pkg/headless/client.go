@@ -0,0 +1,538 @@
+// Package headless connects to a buildorb server without a window, for bots, tests and tools.
+// The windowed client is built on top of it.
+package headless
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// How often a client sends its player's state and checks the server's universe clock
+const (
+	stateInterval     = 50 * time.Millisecond
+	clockSyncInterval = 10 * time.Second
+)
+
+// DefaultReconnectTimeout is how long a client that dialed a server keeps trying to reconnect
+// after its connection drops, before giving up
+const DefaultReconnectTimeout = 2 * time.Minute
+
+// How long a client waits after its first failed attempt to reconnect, doubling up to the longest wait
+const (
+	firstReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay   = 10 * time.Second
+)
+
+// Handlers are called when the server sends something, after the client has updated its own state.
+// Any of them may be nil. They run on the goroutines serving the server's calls, so they may run
+// at the same time as each other.
+// Joined is called once the player and planets are set up, before any of the others.
+// Reconnecting is called when the connection drops and the client starts trying to reconnect,
+// and Reconnected once it has carried on over a new connection.
+type Handlers struct {
+	Joined             func(c *Client)
+	ChunkDelta         func(planet int, applied []common.CellChange)
+	PersonState        func(state *common.PlayerState)
+	PersonDisconnected func(name string)
+	Chat               func(msg common.ChatMessage)
+	ChatHistory        func(msgs []common.ChatMessage)
+	Hit                func(args common.HitPlayerArgs)
+	BlockEntity        func(entity *common.BlockEntity)
+	Teleport           func(args common.TeleportArgs)
+	GameMode           func(mode int)
+	Inventory          func(items []common.Slot)
+	Disconnect         func(reason string)
+	Reconnecting       func()
+	Reconnected        func()
+}
+
+// Client is a player connected to a server. If the connection to the server drops, a client that
+// dialed the server reconnects and carries on as the same player, without respawning.
+type Client struct {
+	Name     string
+	Reply    common.HelloReply
+	Player   *common.Player
+	Planets  map[int]*common.Planet
+	Handlers Handlers
+
+	token string
+	dial  func() (net.Conn, error)
+	stop  chan struct{}
+	once  sync.Once
+
+	// closed is closed once the client is disconnected for good
+	closed chan struct{}
+
+	mutex            sync.Mutex
+	mux              *yamux.Session
+	rpc              *rpc.Client
+	instance         int64
+	reconnecting     bool
+	reconnectTimeout time.Duration
+	people           map[string]*common.PlayerState
+	lastChat         time.Time
+	disconnectReason string
+
+	// The game mode and items the server last sent for the player, waiting for Update to apply them
+	gameMode         int
+	gameModeChanged  bool
+	inventory        []common.Slot
+	inventoryChanged bool
+
+	stateTime   time.Time
+	clockTime   time.Time
+	clockStart  time.Time
+	clock       float64
+	serverClock float64
+	clockCall   *rpc.Call
+}
+
+// rejected is an error from the server turning the player away, which reconnecting would not fix
+type rejected struct {
+	error
+}
+
+// Dial connects to a server and joins as a named player
+func Dial(host string, port int, name, token string, handlers Handlers) (*Client, error) {
+	if host == "" {
+		host = "localhost"
+	}
+	if port == 0 {
+		port = 5555
+	}
+	addr := fmt.Sprintf("%v:%v", host, port)
+	dial := func() (net.Conn, error) {
+		return net.DialTimeout("tcp", addr, common.HandshakeTimeout)
+	}
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	return connect(conn, dial, name, token, handlers)
+}
+
+// Connect joins a server as a named player over an open connection, closing it if joining fails.
+// The client does not reconnect if the connection drops.
+func Connect(conn net.Conn, name, token string, handlers Handlers) (*Client, error) {
+	return connect(conn, nil, name, token, handlers)
+}
+
+// connect joins a server over an open connection, reconnecting with dial if it is not nil
+func connect(conn net.Conn, dial func() (net.Conn, error), name, token string, handlers Handlers) (*Client, error) {
+	// Give the server a deadline to answer the first calls
+	conn.SetDeadline(time.Now().Add(common.HandshakeTimeout))
+	mux, err := yamux.Client(conn, common.MuxConfig())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := &Client{
+		Name:             name,
+		Handlers:         handlers,
+		Planets:          make(map[int]*common.Planet),
+		token:            token,
+		dial:             dial,
+		stop:             make(chan struct{}),
+		closed:           make(chan struct{}),
+		mux:              mux,
+		reconnectTimeout: DefaultReconnectTimeout,
+		people:           make(map[string]*common.PlayerState),
+	}
+	if err := c.join(); err != nil {
+		mux.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	go c.watch()
+	return c, nil
+}
+
+// hello says hello over a new connection and opens the stream for calls on the server
+func (c *Client) hello(mux *yamux.Session, resume bool) (*rpc.Client, common.HelloReply, error) {
+	// Say hello before any API call so the server can turn away incompatible clients
+	helloStream, err := mux.Open()
+	if err != nil {
+		return nil, common.HelloReply{}, err
+	}
+	hello := common.NewHello(c.Name, c.token)
+	hello.Resume = resume
+	reply, err := common.SendHello(helloStream, hello)
+	helloStream.Close()
+	if err != nil {
+		if !reply.Accepted && reply.Message != "" {
+			err = rejected{err}
+		}
+		return nil, reply, err
+	}
+	stream, err := mux.Open()
+	if err != nil {
+		return nil, reply, err
+	}
+	return rpc.NewClient(stream), reply, nil
+}
+
+// join says hello, sets up the streams both ways and fetches the planets
+func (c *Client) join() error {
+	crpc, reply, err := c.hello(c.mux, false)
+	if err != nil {
+		return err
+	}
+	c.Reply = reply
+	c.instance = reply.Instance
+	c.rpc = crpc
+	c.Player = common.NewPlayer(c.Name)
+
+	planetStates := []*common.PlanetState{}
+	if err := c.Call("API.GetPlanetStates", 0, &planetStates); err != nil {
+		return err
+	}
+	for _, state := range planetStates {
+		// Planets make their calls through the client, so they carry on after it reconnects
+		c.Planets[state.ID] = common.NewPlanet(*state, c, nil)
+	}
+	if c.Planets[0] == nil {
+		return errors.New("Server has no planet 0")
+	}
+	if err := c.Call("API.GetUniverseTime", 0, &c.clock); err != nil {
+		return err
+	}
+	c.clockStart = time.Now()
+	c.clockTime = c.clockStart
+
+	serve, err := c.serve(c.mux)
+	if err != nil {
+		return err
+	}
+	if c.Handlers.Joined != nil {
+		c.Handlers.Joined(c)
+	}
+	go serve()
+	return nil
+}
+
+// serve accepts the stream the server opens back to make calls on the client,
+// returning a function that answers the calls
+func (c *Client) serve(mux *yamux.Session) (func(), error) {
+	serverStream, err := mux.Accept()
+	if err != nil {
+		return nil, err
+	}
+	s := rpc.NewServer()
+	if err := s.RegisterName("API", &clientAPI{c}); err != nil {
+		return nil, err
+	}
+	return func() { s.ServeConn(serverStream) }, nil
+}
+
+// watch waits for the connection to close, reconnecting if it dropped,
+// and closes the client for good once it cannot or should not reconnect
+func (c *Client) watch() {
+	for {
+		c.mutex.Lock()
+		mux := c.mux
+		c.mutex.Unlock()
+		<-mux.CloseChan()
+		if !c.reconnect() {
+			close(c.closed)
+			return
+		}
+	}
+}
+
+// reconnect tries to carry on over a new connection, waiting longer after each failed attempt.
+// It returns false without trying if the client was closed, was disconnected by the server or
+// cannot redial, and gives up if the server turns it away or the reconnect timeout passes.
+func (c *Client) reconnect() bool {
+	c.mutex.Lock()
+	timeout := c.reconnectTimeout
+	disconnected := c.disconnectReason != ""
+	c.mutex.Unlock()
+	if c.stopped() || disconnected || c.dial == nil || timeout <= 0 {
+		return false
+	}
+	c.setReconnecting(true)
+	if h := c.Handlers.Reconnecting; h != nil {
+		h()
+	}
+	deadline := time.Now().Add(timeout)
+	delay := firstReconnectDelay
+	for {
+		conn, err := c.dial()
+		if err == nil {
+			err = c.resume(conn)
+		}
+		if err == nil {
+			if c.stopped() {
+				c.Close()
+				return false
+			}
+			c.setReconnecting(false)
+			if h := c.Handlers.Reconnected; h != nil {
+				h()
+			}
+			return true
+		}
+		if _, ok := err.(rejected); ok {
+			c.setDisconnectReason(err.Error())
+			return false
+		}
+		if time.Now().Add(delay).After(deadline) {
+			c.setDisconnectReason(fmt.Sprintf("Lost the connection to the server and could not reconnect within %v: %v", timeout, err))
+			return false
+		}
+		select {
+		case <-c.stop:
+			return false
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// resume carries on as the same player over a new connection. The server keeps the player's place,
+// and only the chunks that changed while the client was away are fetched again.
+func (c *Client) resume(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(common.HandshakeTimeout))
+	mux, err := yamux.Client(conn, common.MuxConfig())
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	err = c.resumeOver(mux)
+	if err != nil {
+		mux.Close()
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	return nil
+}
+
+// resumeOver says hello as a resuming player, checks the server still has the same planets
+// and subscribes again to the chunks the client holds
+func (c *Client) resumeOver(mux *yamux.Session) error {
+	crpc, reply, err := c.hello(mux, true)
+	if err != nil {
+		return err
+	}
+	planetStates := []*common.PlanetState{}
+	if err := crpc.Call("API.GetPlanetStates", 0, &planetStates); err != nil {
+		return err
+	}
+	if len(planetStates) != len(c.Planets) {
+		return rejected{errors.New("The server's world has changed")}
+	}
+	for _, state := range planetStates {
+		p := c.Planets[state.ID]
+		if p == nil || p.Name != state.Name || p.Seed != state.Seed || p.GeneratorType != state.GeneratorType || p.Radius != state.Radius {
+			return rejected{errors.New("The server's world has changed")}
+		}
+	}
+
+	c.mutex.Lock()
+	c.mux = mux
+	c.rpc = crpc
+	sameNumbers := reply.Instance == c.instance
+	people := c.people
+	c.people = make(map[string]*common.PlayerState)
+	c.mutex.Unlock()
+
+	// The server sends the people in view again, and anyone who left meanwhile is gone
+	if h := c.Handlers.PersonDisconnected; h != nil {
+		for name := range people {
+			h(name)
+		}
+	}
+	serve, err := c.serve(mux)
+	if err != nil {
+		return err
+	}
+	go serve()
+	for _, p := range c.Planets {
+		if err := p.ResubscribeChunks(sameNumbers); err != nil {
+			return err
+		}
+	}
+	c.mutex.Lock()
+	c.instance = reply.Instance
+	c.mutex.Unlock()
+	return nil
+}
+
+// stopped returns whether Close was called
+func (c *Client) stopped() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// setReconnecting records whether the client is trying to reconnect
+func (c *Client) setReconnecting(reconnecting bool) {
+	c.mutex.Lock()
+	c.reconnecting = reconnecting
+	c.mutex.Unlock()
+}
+
+// Reconnecting returns whether the connection dropped and the client is trying to reconnect.
+// The player stays where they are until it has.
+func (c *Client) Reconnecting() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.reconnecting
+}
+
+// SetReconnectTimeout sets how long the client keeps trying to reconnect before giving up,
+// with zero turning reconnecting off
+func (c *Client) SetReconnectTimeout(timeout time.Duration) {
+	c.mutex.Lock()
+	c.reconnectTimeout = timeout
+	c.mutex.Unlock()
+}
+
+// setDisconnectReason records why the player was disconnected
+func (c *Client) setDisconnectReason(reason string) {
+	c.mutex.Lock()
+	c.disconnectReason = reason
+	c.mutex.Unlock()
+}
+
+// Call calls a server method and waits for it to finish
+func (c *Client) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	return Wait(c.Go(serviceMethod, args, reply, nil))
+}
+
+// Go calls a server method without waiting. Calls made while the client is reconnecting
+// fail straight away, and calls pending when the connection drops fail once it is noticed.
+func (c *Client) Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+	c.mutex.Lock()
+	crpc := c.rpc
+	c.mutex.Unlock()
+	return crpc.Go(serviceMethod, args, reply, done)
+}
+
+// Closed returns a channel that is closed once the client is disconnected for good
+func (c *Client) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// Close disconnects from the server
+func (c *Client) Close() error {
+	c.once.Do(func() { close(c.stop) })
+	c.mutex.Lock()
+	mux := c.mux
+	c.mutex.Unlock()
+	return mux.Close()
+}
+
+// DisconnectReason returns why the player was disconnected, either by the server
+// or by failing to reconnect, if they were
+func (c *Client) DisconnectReason() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.disconnectReason
+}
+
+// People returns the last states of the other people the server is sending
+func (c *Client) People() []common.PlayerState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	people := make([]common.PlayerState, 0, len(c.people))
+	for _, p := range c.people {
+		people = append(people, *p)
+	}
+	return people
+}
+
+// Spawn puts the player at the spawn of a planet, loading the chunks around it
+func (c *Client) Spawn(planetID int) error {
+	planet := c.Planets[planetID]
+	if planet == nil {
+		return errors.New("Unknown planet ID")
+	}
+	c.Player.Planet = planet
+	c.Player.Spawn()
+	return nil
+}
+
+// Update moves the player by h seconds, loading the chunks around them,
+// and sends their state and checks the universe clock when they are due.
+// While the client is reconnecting the player does not move.
+func (c *Client) Update(h float32) {
+	if c.Reconnecting() {
+		return
+	}
+	c.Player.UpdatePosition(h)
+	c.updatePlayer()
+	now := time.Now()
+	if now.Sub(c.stateTime) > stateInterval {
+		c.stateTime = now
+		c.SendState()
+	}
+	if c.clockCall == nil && now.Sub(c.clockTime) > clockSyncInterval {
+		c.clockTime = now
+		c.clockCall = c.Go("API.GetUniverseTime", 0, &c.serverClock, nil)
+	}
+	if c.clockCall != nil {
+		select {
+		case <-c.clockCall.Done:
+			if c.clockCall.Error == nil {
+				c.clock = c.serverClock
+				c.clockStart = now
+			}
+			c.clockCall = nil
+		default:
+		}
+	}
+}
+
+// updatePlayer applies the game mode the server last sent and arranges the items it last sent in the
+// player's slots. Creative players use what is on their hotbar, so the items wait until they are in survival mode.
+func (c *Client) updatePlayer() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.gameModeChanged {
+		c.Player.GameMode = c.gameMode
+		c.gameModeChanged = false
+	}
+	if c.inventoryChanged && c.Player.GameMode == common.Survival {
+		c.Player.SetItems(c.inventory)
+		c.inventoryChanged = false
+	}
+}
+
+// UniverseTime returns the server's universe clock in seconds, as of the last check plus the time since
+func (c *Client) UniverseTime() float64 {
+	return c.clock + time.Since(c.clockStart).Seconds()
+}
+
+// Wait waits for a call to finish, returning its error
+func Wait(call *rpc.Call) error {
+	return (<-call.Done).Error
+}
+
+// QueryStatus asks a server for its status without joining, returning it along with how long the server took to answer
+func QueryStatus(host string, port int) (*common.Status, time.Duration, error) {
+	if host == "" {
+		host = "localhost"
+	}
+	if port == 0 {
+		port = 5555
+	}
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%v:%v", host, port), common.StatusTimeout)
+	if err != nil {
+		return nil, 0, err
+	}
+	return common.QueryStatus(conn)
+}
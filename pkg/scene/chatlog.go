@@ -0,0 +1,72 @@
+package scene
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// chatLogSize is how many lines of chat the client keeps
+const chatLogSize = 500
+
+// ChatLog holds the lines of chat the player has received, and how far back they have scrolled
+type ChatLog struct {
+	mutex  sync.Mutex
+	lines  []string
+	scroll int
+}
+
+// Add adds messages to the end of the log
+func (c *ChatLog) Add(msgs ...common.ChatMessage) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, msg := range msgs {
+		for _, line := range strings.Split(msg.String(), "\n") {
+			c.lines = append(c.lines, line)
+			if c.scroll > 0 {
+				// Keep showing the same lines while scrolled back
+				c.scroll++
+			}
+		}
+	}
+	if len(c.lines) > chatLogSize {
+		c.lines = c.lines[len(c.lines)-chatLogSize:]
+	}
+	c.clampScroll()
+}
+
+// Lines returns up to n lines, newest first, ending where the player has scrolled to
+func (c *ChatLog) Lines(n int) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	lines := []string{}
+	for i := len(c.lines) - 1 - c.scroll; i >= 0 && len(lines) < n; i-- {
+		lines = append(lines, c.lines[i])
+	}
+	return lines
+}
+
+// Scroll moves back through older lines, or forward through newer ones for a negative count
+func (c *ChatLog) Scroll(lines int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.scroll += lines
+	c.clampScroll()
+}
+
+// ResetScroll goes back to showing the newest lines
+func (c *ChatLog) ResetScroll() {
+	c.mutex.Lock()
+	c.scroll = 0
+	c.mutex.Unlock()
+}
+
+func (c *ChatLog) clampScroll() {
+	if c.scroll > len(c.lines)-1 {
+		c.scroll = len(c.lines) - 1
+	}
+	if c.scroll < 0 {
+		c.scroll = 0
+	}
+}
@@ -2,6 +2,7 @@ package scene
 
 import (
 	"errors"
+	"math"
 
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/go-gl/mathgl/mgl32"
@@ -14,6 +15,7 @@ type chunkRenderer struct {
 	pointsVBO       uint32
 	normalsVBO      uint32
 	tcoordsVBO      uint32
+	lightsVBO       uint32
 	numTriangles    int32
 	geometryUpdated bool
 }
@@ -24,11 +26,42 @@ func newChunkRenderer(chunk *common.Chunk) *chunkRenderer {
 	cr.pointsVBO = newVBO()
 	cr.normalsVBO = newVBO()
 	cr.tcoordsVBO = newVBO()
-	cr.drawableVAO = newPointsNormalsTcoordsVAO(cr.pointsVBO, cr.normalsVBO, cr.tcoordsVBO)
+	cr.lightsVBO = newVBO()
+	cr.drawableVAO = newPointsNormalsTcoordsLightsVAO(cr.pointsVBO, cr.normalsVBO, cr.tcoordsVBO, cr.lightsVBO)
 	return &cr
 }
 
-func generateFace(cellIndex common.CellIndex, planet *common.Planet, points []float32, tcoords []float32, lonWidth, latWidth int, material int) (pts []float32, nms []float32, tcs []float32) {
+// lightCurve maps a light level to a brightness, with each level 80% as bright as the one above it
+var lightCurve = func() (curve [common.MaxLight + 1]float32) {
+	for level := range curve {
+		curve[level] = float32(math.Pow(0.8, float64(common.MaxLight-level)))
+	}
+	curve[0] = 0
+	return
+}()
+
+// orientTcoords lays a texture along the axis of an oriented cell, so the texture's
+// vertical direction follows the axis on every face the axis lies in
+func orientTcoords(points []float32, tcoords []float32, orientation int) []float32 {
+	axis := [...]int{2, 2, 0, 0, 1, 1}[orientation]
+	normal := 0
+	for normal < 2 && (points[normal] != points[normal+3] || points[normal] != points[normal+6]) {
+		normal++
+	}
+	if axis == 2 || axis == normal {
+		return tcoords
+	}
+	other := 3 - axis - normal
+	tcs := make([]float32, len(tcoords))
+	for i, j := 0, 0; i < len(points); i, j = i+3, j+2 {
+		tcs[j+0] = points[i+other] + 0.5
+		tcs[j+1] = points[i+axis] + 0.5
+	}
+	return tcs
+}
+
+func generateFace(cellIndex common.CellIndex, planet *common.Planet, points []float32, tcoords []float32, lonWidth, latWidth int, cell *common.Cell, facing common.CellIndex) (pts []float32, nms []float32, tcs []float32, lts []float32) {
+	material := cell.Material
 	pts = make([]float32, len(points))
 	for i := 0; i < len(points); i += 3 {
 		l := common.CellLoc{
@@ -58,10 +91,21 @@ func generateFace(cellIndex common.CellIndex, planet *common.Planet, points []fl
 		}
 	}
 
+	if common.IsOriented(material) {
+		tcoords = orientTcoords(points, tcoords, cell.State.Orientation())
+	}
 	tcs = make([]float32, len(tcoords))
 	for i := 0; i < len(tcoords); i += 2 {
-		tcs[i+0] = (tcoords[i+0] + float32(material%4)) / 4
-		tcs[i+1] = (tcoords[i+1] + float32(material/4)) / 4
+		tcs[i+0] = (tcoords[i+0] + float32(material%textureColumns)) / textureColumns
+		tcs[i+1] = (tcoords[i+1] + float32(material/textureColumns)) / textureColumns
+	}
+
+	// The face is lit by the cell it faces
+	sky, block := planet.CellLight(facing)
+	lts = make([]float32, 2*len(points)/3)
+	for i := 0; i < len(lts); i += 2 {
+		lts[i+0] = lightCurve[sky]
+		lts[i+1] = lightCurve[block]
 	}
 
 	return
@@ -72,6 +116,7 @@ func (cr *chunkRenderer) updateGeometry(planet *common.Planet, lonIndex, latInde
 	points := []float32{}
 	normals := []float32{}
 	tcoords := []float32{}
+	lights := []float32{}
 
 	lonCells, latCells := planet.LonLatCellsInChunkIndex(common.ChunkIndex{Lon: lonIndex, Lat: latIndex, Alt: altIndex})
 	lonWidth := common.ChunkSize / lonCells
@@ -148,40 +193,46 @@ func (cr *chunkRenderer) updateGeometry(planet *common.Planet, lonIndex, latInde
 				cell := cr.chunk.Cells[cLon][cLat][cAlt]
 				if cell.Material != common.Air {
 					if (cAlt+1 >= cs && chunkPosAlt != nil && hasAirAlt(chunkPosAlt, cLon, cLat, 0)) || (cAlt+1 >= cs && maxAltChunk) || (cAlt+1 < cs && cr.chunk.Cells[cLon][cLat][cAlt+1].Material == common.Air) {
-						pts, nms, tcs := generateFace(cellIndex, planet, cubePosZ, cubeTcoordPosZ, lonWidth, latWidth, cell.Material)
+						pts, nms, tcs, lts := generateFace(cellIndex, planet, cubePosZ, cubeTcoordPosZ, lonWidth, latWidth, cell, common.CellIndex{Lon: cellIndex.Lon, Lat: cellIndex.Lat, Alt: cellIndex.Alt + 1})
 						points = append(points, pts...)
 						normals = append(normals, nms...)
 						tcoords = append(tcoords, tcs...)
+						lights = append(lights, lts...)
 					}
 					if (cAlt-1 < 0 && chunkNegAlt != nil && hasAirAlt(chunkNegAlt, cLon, cLat, cs-1)) || (cAlt-1 < 0 && minAltChunk) || (cAlt-1 >= 0 && cr.chunk.Cells[cLon][cLat][cAlt-1].Material == common.Air) {
-						pts, nms, tcs := generateFace(cellIndex, planet, cubeNegZ, cubeTcoordNegZ, lonWidth, latWidth, cell.Material)
+						pts, nms, tcs, lts := generateFace(cellIndex, planet, cubeNegZ, cubeTcoordNegZ, lonWidth, latWidth, cell, common.CellIndex{Lon: cellIndex.Lon, Lat: cellIndex.Lat, Alt: cellIndex.Alt - 1})
 						points = append(points, pts...)
 						normals = append(normals, nms...)
 						tcoords = append(tcoords, tcs...)
+						lights = append(lights, lts...)
 					}
 					if (cLon+1 >= lonCells && chunkPosLon != nil && hasAirLon(chunkPosLon, 0, cLat, cAlt)) || (cLon+1 < lonCells && cr.chunk.Cells[cLon+1][cLat][cAlt].Material == common.Air) {
-						pts, nms, tcs := generateFace(cellIndex, planet, cubePosX, cubeTcoordPosX, lonWidth, latWidth, cell.Material)
+						pts, nms, tcs, lts := generateFace(cellIndex, planet, cubePosX, cubeTcoordPosX, lonWidth, latWidth, cell, common.CellIndex{Lon: cellIndex.Lon + lonWidth, Lat: cellIndex.Lat, Alt: cellIndex.Alt})
 						points = append(points, pts...)
 						normals = append(normals, nms...)
 						tcoords = append(tcoords, tcs...)
+						lights = append(lights, lts...)
 					}
 					if (cLon-1 < 0 && chunkNegLon != nil && hasAirLon(chunkNegLon, lonCells-1, cLat, cAlt)) || (cLon-1 >= 0 && cr.chunk.Cells[cLon-1][cLat][cAlt].Material == common.Air) {
-						pts, nms, tcs := generateFace(cellIndex, planet, cubeNegX, cubeTcoordNegX, lonWidth, latWidth, cell.Material)
+						pts, nms, tcs, lts := generateFace(cellIndex, planet, cubeNegX, cubeTcoordNegX, lonWidth, latWidth, cell, common.CellIndex{Lon: cellIndex.Lon - 1, Lat: cellIndex.Lat, Alt: cellIndex.Alt})
 						points = append(points, pts...)
 						normals = append(normals, nms...)
 						tcoords = append(tcoords, tcs...)
+						lights = append(lights, lts...)
 					}
 					if (cLat+1 >= latCells && chunkPosLat != nil && hasAirLat(chunkPosLat, cLon, 0, cAlt)) || (cLat+1 < latCells && cr.chunk.Cells[cLon][cLat+1][cAlt].Material == common.Air) {
-						pts, nms, tcs := generateFace(cellIndex, planet, cubePosY, cubeTcoordPosY, lonWidth, latWidth, cell.Material)
+						pts, nms, tcs, lts := generateFace(cellIndex, planet, cubePosY, cubeTcoordPosY, lonWidth, latWidth, cell, common.CellIndex{Lon: cellIndex.Lon, Lat: cellIndex.Lat + latWidth, Alt: cellIndex.Alt})
 						points = append(points, pts...)
 						normals = append(normals, nms...)
 						tcoords = append(tcoords, tcs...)
+						lights = append(lights, lts...)
 					}
 					if (cLat-1 < 0 && chunkNegLat != nil && hasAirLat(chunkNegLat, cLon, latCells-1, cAlt)) || (cLat-1 >= 0 && cr.chunk.Cells[cLon][cLat-1][cAlt].Material == common.Air) {
-						pts, nms, tcs := generateFace(cellIndex, planet, cubeNegY, cubeTcoordNegY, lonWidth, latWidth, cell.Material)
+						pts, nms, tcs, lts := generateFace(cellIndex, planet, cubeNegY, cubeTcoordNegY, lonWidth, latWidth, cell, common.CellIndex{Lon: cellIndex.Lon, Lat: cellIndex.Lat - 1, Alt: cellIndex.Alt})
 						points = append(points, pts...)
 						normals = append(normals, nms...)
 						tcoords = append(tcoords, tcs...)
+						lights = append(lights, lts...)
 					}
 				}
 			}
@@ -192,6 +243,7 @@ func (cr *chunkRenderer) updateGeometry(planet *common.Planet, lonIndex, latInde
 		fillVBO(cr.pointsVBO, points)
 		fillVBO(cr.normalsVBO, normals)
 		fillVBO(cr.tcoordsVBO, tcoords)
+		fillVBO(cr.lightsVBO, lights)
 		cr.geometryUpdated = true
 	}
 }
@@ -202,3 +254,10 @@ func (cr *chunkRenderer) draw() {
 		gl.DrawArrays(gl.TRIANGLES, 0, cr.numTriangles)
 	}
 }
+
+// destroy frees the renderer's buffers
+func (cr *chunkRenderer) destroy() {
+	gl.DeleteVertexArrays(1, &cr.drawableVAO)
+	buffers := []uint32{cr.pointsVBO, cr.normalsVBO, cr.tcoordsVBO, cr.lightsVBO}
+	gl.DeleteBuffers(int32(len(buffers)), &buffers[0])
+}
@@ -9,12 +9,18 @@ import (
 	"github.com/jeffbaumes/buildorb/pkg/common"
 )
 
-// FocusCell draws an outline around the focused cell
+// FocusCell draws an outline around the focused cell, and cracks on it while it is being mined
 type FocusCell struct {
-	program           uint32
-	drawableVAO       uint32
-	pointsVBO         uint32
-	projectionUniform int32
+	program                uint32
+	drawableVAO            uint32
+	pointsVBO              uint32
+	projectionUniform      int32
+	crackProgram           uint32
+	crackVAO               uint32
+	crackPointsVBO         uint32
+	crackTcoordsVBO        uint32
+	crackProjectionUniform int32
+	crackProgressUniform   int32
 }
 
 // NewFocusCell creates a new focus cell object
@@ -46,29 +52,72 @@ func NewFocusCell() *FocusCell {
 	}
 	focusRen.pointsVBO = newVBO()
 	focusRen.drawableVAO = newPointsVAO(focusRen.pointsVBO, 3)
+
+	const crackVertexShader = `
+		#version 410
+		uniform mat4 proj;
+		in vec3 position;
+		in vec2 tcoord;
+		out vec2 t;
+		void main() {
+			t = tcoord;
+			gl_Position = proj * vec4(position, 1.0);
+		}
+	`
+
+	// Each face is split into an 8x8 grid, and more of the grid is darkened as progress grows
+	const crackFragmentShader = `
+		#version 410
+		uniform float progress;
+		in vec2 t;
+		out vec4 frag_color;
+		void main() {
+			vec2 square = floor(t * 8.0);
+			float r = fract(sin(dot(square, vec2(12.9898, 78.233))) * 43758.5453);
+			if (r > progress) {
+				discard;
+			}
+			frag_color = vec4(0, 0, 0, 0.5);
+		}
+	`
+
+	focusRen.crackProgram = createProgram(crackVertexShader, crackFragmentShader)
+	bindAttribute(focusRen.crackProgram, 0, "position")
+	bindAttribute(focusRen.crackProgram, 1, "tcoord")
+	focusRen.crackProjectionUniform = uniformLocation(focusRen.crackProgram, "proj")
+	focusRen.crackProgressUniform = uniformLocation(focusRen.crackProgram, "progress")
+	focusRen.crackPointsVBO = newVBO()
+	focusRen.crackTcoordsVBO = newVBO()
+	fillVBO(focusRen.crackTcoordsVBO, cubeTcoords)
+	focusRen.crackVAO = newPointsTcoordsVAO(focusRen.crackPointsVBO, focusRen.crackTcoordsVBO)
 	return &focusRen
 }
 
-// Draw draws an outline around the focused cell
-func (focusRen *FocusCell) Draw(player *common.Player, planet *common.Planet, w *glfw.Window) {
-	gl.UseProgram(focusRen.program)
-	lonCells, latCells := planet.LonLatCellsInChunkIndex(planet.CellIndexToChunkIndex(player.FocusCellIndex))
+// cellPoints maps points around the unit cube to world space around a cell, scaled about the cell center
+func cellPoints(planet *common.Planet, index common.CellIndex, unit []float32, scale float32) []float32 {
+	lonCells, latCells := planet.LonLatCellsInChunkIndex(planet.CellIndexToChunkIndex(index))
 	lonWidth := common.ChunkSize / lonCells
 	latWidth := common.ChunkSize / latCells
 
-	pts := make([]float32, len(box))
-	for i := 0; i < len(box); i += 3 {
+	pts := make([]float32, len(unit))
+	for i := 0; i < len(unit); i += 3 {
 		ind := common.CellLoc{
-			Lon: float32(player.FocusCellIndex.Lon/lonWidth*lonWidth) + float32(lonWidth-1)/2 + float32(lonWidth)*(box[i+0]*1.01),
-			Lat: float32(player.FocusCellIndex.Lat/latWidth*latWidth) + float32(latWidth-1)/2 + float32(latWidth)*(box[i+1]*1.01),
-			Alt: float32(player.FocusCellIndex.Alt) + (box[i+2] * 1.01),
+			Lon: float32(index.Lon/lonWidth*lonWidth) + float32(lonWidth-1)/2 + float32(lonWidth)*(unit[i+0]*scale),
+			Lat: float32(index.Lat/latWidth*latWidth) + float32(latWidth-1)/2 + float32(latWidth)*(unit[i+1]*scale),
+			Alt: float32(index.Alt) + (unit[i+2] * scale),
 		}
 		pt := planet.CellLocToCartesian(ind)
 		pts[i+0] = pt.X()
 		pts[i+1] = pt.Y()
 		pts[i+2] = pt.Z()
 	}
+	return pts
+}
 
+// Draw draws an outline around the focused cell
+func (focusRen *FocusCell) Draw(player *common.Player, planet *common.Planet, w *glfw.Window) {
+	gl.UseProgram(focusRen.program)
+	pts := cellPoints(planet, player.FocusCellIndex, box, 1.01)
 	fillVBO(focusRen.pointsVBO, pts)
 
 	lookDir := player.LookDir()
@@ -80,4 +129,14 @@ func (focusRen *FocusCell) Draw(player *common.Player, planet *common.Planet, w
 
 	gl.BindVertexArray(focusRen.drawableVAO)
 	gl.DrawArrays(gl.TRIANGLES, 0, int32(len(pts)/3))
+
+	if player.MiningProgress <= 0 || player.MiningCellIndex != player.FocusCellIndex {
+		return
+	}
+	gl.UseProgram(focusRen.crackProgram)
+	fillVBO(focusRen.crackPointsVBO, cellPoints(planet, player.FocusCellIndex, cube, 1.005))
+	gl.UniformMatrix4fv(focusRen.crackProjectionUniform, 1, false, &proj[0])
+	gl.Uniform1f(focusRen.crackProgressUniform, player.MiningProgress)
+	gl.BindVertexArray(focusRen.crackVAO)
+	gl.DrawArrays(gl.TRIANGLES, 0, int32(len(cube)/3))
 }
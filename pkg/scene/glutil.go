@@ -70,6 +70,14 @@ func newPointsNormalsTcoordsVAO(pointsVBO, normalsVBO, tcoordsVBO uint32) uint32
 	return vao
 }
 
+func newPointsNormalsTcoordsLightsVAO(pointsVBO, normalsVBO, tcoordsVBO, lightsVBO uint32) uint32 {
+	vao := newPointsNormalsTcoordsVAO(pointsVBO, normalsVBO, tcoordsVBO)
+	gl.BindBuffer(gl.ARRAY_BUFFER, lightsVBO)
+	gl.VertexAttribPointer(3, 2, gl.FLOAT, false, 0, nil)
+	gl.EnableVertexAttribArray(3)
+	return vao
+}
+
 func newPointsColorsVAO(pointsVBO, colorsVBO uint32) uint32 {
 	var vao uint32
 	gl.GenVertexArrays(1, &vao)
@@ -96,6 +104,19 @@ func newPointsNormalsVAO(pointsVBO, normalsVBO uint32) uint32 {
 	return vao
 }
 
+func newPointsTcoordsVAO(pointsVBO, tcoordsVBO uint32) uint32 {
+	var vao uint32
+	gl.GenVertexArrays(1, &vao)
+	gl.BindVertexArray(vao)
+	gl.EnableVertexAttribArray(0)
+	gl.BindBuffer(gl.ARRAY_BUFFER, pointsVBO)
+	gl.VertexAttribPointer(0, 3, gl.FLOAT, false, 0, nil)
+	gl.EnableVertexAttribArray(1)
+	gl.BindBuffer(gl.ARRAY_BUFFER, tcoordsVBO)
+	gl.VertexAttribPointer(1, 2, gl.FLOAT, false, 0, nil)
+	return vao
+}
+
 func newPointsVAO(pointsVBO uint32, size int32) uint32 {
 	var vao uint32
 	gl.GenVertexArrays(1, &vao)
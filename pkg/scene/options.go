@@ -28,6 +28,9 @@ func newOption(key glfw.Key) *Option {
 // Options holds all the user-defined options for controlling the game
 type Options struct {
 	OptionMap map[string]*Option
+
+	// ReconnectSeconds is how long to keep trying to reconnect after the connection to the server drops
+	ReconnectSeconds int
 }
 
 func writefile(t string) {
@@ -58,6 +61,13 @@ func load(o *Options) {
 		if err != nil {
 			panic(err)
 		}
+		if g[0] == "Reconnect" {
+			o.ReconnectSeconds = n
+			continue
+		}
+		if o.OptionMap[g[0]] == nil {
+			continue
+		}
 		f := glfw.Key(n)
 		o.OptionMap[g[0]].Key = f
 	}
@@ -71,12 +81,13 @@ func save(o *Options) {
 		}
 		strs = append(strs, fmt.Sprintf("%v=%v", name, opt.Key))
 	}
+	strs = append(strs, fmt.Sprintf("Reconnect=%v", o.ReconnectSeconds))
 	writefile(strings.Join(strs, ";"))
 }
 
 // NewOptions creates a new options GUI
 func NewOptions(screen *gui.Screen) *Options {
-	o := Options{}
+	o := Options{ReconnectSeconds: 120}
 	o.OptionMap = make(map[string]*Option)
 	m := o.OptionMap
 	m["Apex"] = newOption(glfw.KeyK)
@@ -105,6 +116,7 @@ func NewOptions(screen *gui.Screen) *Options {
 	m["Run"] = newOption(glfw.KeyLeftShift)
 	m["FlySprint"] = newOption(glfw.KeyLeftControl)
 	m["Inventory"] = newOption(glfw.KeyE)
+	m["Interact"] = newOption(glfw.KeyF)
 	m["Build"] = newOption(glfw.Key(1))
 	m["Destroy"] = newOption(glfw.Key(0))
 	load(&o)
@@ -126,6 +138,8 @@ func NewOptions(screen *gui.Screen) *Options {
 	m["FlySprint"].label = gui.NewLabel(screen, "FlySprint:", 0.25, 0.60, 0.09)
 	m["Inventory"].entry = gui.NewKeyEntry(screen, m["Inventory"].Key, 0.75, 0.35, 0.2, 0.15, 0.05, nil)
 	m["Inventory"].label = gui.NewLabel(screen, "Inventory:", 0.25, 0.40, 0.09)
+	m["Interact"].entry = gui.NewKeyEntry(screen, m["Interact"].Key, 0.75, -0.25, 0.2, 0.15, 0.05, nil)
+	m["Interact"].label = gui.NewLabel(screen, "Interact:", 0.25, -0.20, 0.09)
 	m["Build"].entry = gui.NewKeyEntry(screen, m["Build"].Key, 0.75, -0.05, 0.2, 0.15, 0.05, nil)
 	m["Build"].label = gui.NewLabel(screen, "Build:", 0.25, 0, 0.09)
 	m["Destroy"].entry = gui.NewKeyEntry(screen, m["Destroy"].Key, 0.75, 0.15, 0.2, 0.15, 0.05, nil)
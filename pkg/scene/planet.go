@@ -79,6 +79,7 @@ func NewPlanet(planet *common.Planet) *Planet {
 		in vec3 vp;
 		in vec3 n;
 		in vec2 t;
+		in vec2 l;
 		uniform mat4 proj;
 		uniform mat3 planetrot;
 		uniform vec3 planetloc;
@@ -100,7 +101,12 @@ func NewPlanet(planet *common.Planet) *Planet {
 			highp float light2 = max(sqrt(1 - dot(vpn, sundir)), 0.0);
 			highp vec3 light3Color = vec3(1.0, 0.5, 0.1);
 			highp float light3 = max(0.4 - sqrt(abs(dot(vpn, sundir))), 0.0);
-			light = ambientLight + (light1Color * light1) + (light2Color * light2) + (light3Color * light3);
+			highp vec3 sunLight = (light1Color * light1) + (light2Color * light2) + (light3Color * light3);
+
+			// Sky light (l.x) is scaled by the sun, block light (l.y) glows warm regardless of time of day
+			highp vec3 caveLight = vec3(0.03, 0.03, 0.03);
+			highp vec3 blockLightColor = vec3(1.0, 0.85, 0.6);
+			light = caveLight + max(l.x * sunLight, l.y * blockLightColor);
 		}
 	`
 
@@ -123,6 +129,7 @@ func NewPlanet(planet *common.Planet) *Planet {
 	bindAttribute(pr.chunkProgram, 0, "vp")
 	bindAttribute(pr.chunkProgram, 1, "n")
 	bindAttribute(pr.chunkProgram, 2, "t")
+	bindAttribute(pr.chunkProgram, 3, "l")
 	gl.LinkProgram(pr.chunkProgram)
 	pr.chunkProjectionUniform = uniformLocation(pr.chunkProgram, "proj")
 	pr.chunkPlanetLocUniform = uniformLocation(pr.chunkProgram, "planetloc")
@@ -174,7 +181,38 @@ func NewPlanet(planet *common.Planet) *Planet {
 
 // SetCellMaterial sets the material at a particular cell and marks its chunk for redraw
 func (planetRen *Planet) SetCellMaterial(ind common.CellIndex, material int, updateServer bool) {
-	planetRen.Planet.SetCellMaterial(ind, material, updateServer)
+	planetRen.SetCellMaterialState(ind, material, 0, updateServer)
+}
+
+// SetCellMaterialState sets the material and block state at a particular cell and marks its chunk for redraw
+func (planetRen *Planet) SetCellMaterialState(ind common.CellIndex, material int, state common.CellState, updateServer bool) {
+	planetRen.Planet.SetCellMaterialState(ind, material, state, updateServer)
+	planetRen.markCellChanged(ind)
+}
+
+// RevertCell puts back a cell changed ahead of the server, unless a change from the server
+// has replaced the predicted cell in the meantime
+func (planetRen *Planet) RevertCell(ind common.CellIndex, predicted, old common.Cell) {
+	planetRen.Planet.DeltaMutex.Lock()
+	cell := planetRen.Planet.CellIndexToCell(ind)
+	if cell == nil || *cell != predicted {
+		planetRen.Planet.DeltaMutex.Unlock()
+		return
+	}
+	planetRen.Planet.SetCellMaterialState(ind, old.Material, old.State, false)
+	planetRen.Planet.DeltaMutex.Unlock()
+	planetRen.markCellChanged(ind)
+}
+
+// CellsChanged marks the chunks holding changed cells for redraw
+func (planetRen *Planet) CellsChanged(changes []common.CellChange) {
+	for _, change := range changes {
+		planetRen.markCellChanged(change.Index)
+	}
+}
+
+// markCellChanged marks the chunk holding a cell for redraw, along with any neighboring chunk that shares a face with the cell
+func (planetRen *Planet) markCellChanged(ind common.CellIndex) {
 	chunkInd := planetRen.Planet.CellIndexToChunkIndex(ind)
 	chunkRen := planetRen.chunkRenderers[chunkInd]
 	if chunkRen == nil {
@@ -301,11 +339,40 @@ func (planetRen *Planet) Draw(player *common.Player, planetMap map[int]*Planet,
 		if chunk.WaitingForData {
 			continue
 		}
+
+		// Chunks are drawn once their light is known
+		if !planetRen.Planet.UpdateChunkLight(key) {
+			continue
+		}
 		cr := planetRen.chunkRenderers[key]
+		if cr != nil && cr.chunk != chunk {
+			// The chunk was fetched again
+			cr.destroy()
+			cr = nil
+		}
 		if cr == nil {
 			cr = newChunkRenderer(chunk)
 			planetRen.chunkRenderers[key] = cr
 		}
+	}
+
+	// Free the renderers of unloaded chunks
+	for key, cr := range planetRen.chunkRenderers {
+		if planetRen.Planet.Chunks[key] == nil {
+			cr.destroy()
+			delete(planetRen.chunkRenderers, key)
+		}
+	}
+
+	// Rebuild every chunk whose light levels changed
+	for _, key := range planetRen.Planet.TakeLightChanges() {
+		cr := planetRen.chunkRenderers[key]
+		if cr != nil {
+			cr.geometryUpdated = false
+		}
+	}
+
+	for key, cr := range planetRen.chunkRenderers {
 		if !cr.geometryUpdated {
 			cr.updateGeometry(planetRen.Planet, key.Lon, key.Lat, key.Alt)
 		}
@@ -17,7 +17,6 @@ var o int
 var tex1 *gui.Label
 var texte *gui.Entry
 var textl [5]*gui.Label
-var oldtext [4]string
 var h bool
 
 // Draw draws the overlay text
@@ -31,8 +30,10 @@ func (text *Text) Draw(player *common.Player, screen *gui.Screen, u *Universe) {
 
 		texte = gui.NewEntry(screen, "", -0.75, -0.85, 1.5, 0.2, 0.04, func() {
 			player.Mode = "Play"
-			var ret bool
-			u.RPC.Go("API.SendText", fmt.Sprintf("%v: %v", player.Name, texte.Text), &ret, nil)
+			u.Chat.ResetScroll()
+			if texte.Text != "" {
+				sendChat(u, texte.Text)
+			}
 			texte.Text = ""
 		})
 		o = 1
@@ -46,15 +47,29 @@ func (text *Text) Draw(player *common.Player, screen *gui.Screen, u *Universe) {
 		texte.Y = 10
 		texte.Focus = false
 	}
-	if player.DrawText != oldtext[0] {
-		textl[0].Text = player.DrawText
-		textl[1].Text = oldtext[0]
-		textl[2].Text = oldtext[1]
-		textl[3].Text = oldtext[2]
-		textl[4].Text = oldtext[3]
-		oldtext[3] = oldtext[2]
-		oldtext[2] = oldtext[1]
-		oldtext[1] = oldtext[0]
-		oldtext[0] = player.DrawText
+
+	// Messages for the player alone go in the chat log too, so each one shows even if it repeats
+	if player.DrawText != "" {
+		u.Chat.Add(common.ChatMessage{Channel: common.ChatNotice, Text: player.DrawText})
+		player.DrawText = ""
+	}
+	lines := u.Chat.Lines(len(textl))
+	for i := range textl {
+		textl[i].Text = ""
+		if i < len(lines) {
+			textl[i].Text = lines[i]
+		}
 	}
 }
+
+// sendChat sends a chat message, showing why if the server refuses it
+func sendChat(u *Universe, text string) {
+	var ret bool
+	call := u.RPC.Go("API.Chat", text, &ret, nil)
+	go func() {
+		call = <-call.Done
+		if call.Error != nil {
+			u.Player.DrawText = call.Error.Error()
+		}
+	}()
+}
@@ -12,9 +12,12 @@ import (
 	"github.com/jeffbaumes/buildorb/pkg/common"
 )
 
+// textureColumns is the number of 16x16 material textures across each side of the texture atlas
+const textureColumns = 8
+
 // LoadTextures loads textures from the textures directory into a single texture image
 func LoadTextures() *image.RGBA {
-	rgba := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	rgba := image.NewRGBA(image.Rect(0, 0, textureColumns*16, textureColumns*16))
 	for x := 0; x < len(common.Materials); x++ {
 		ImageFile, err := os.Open(fmt.Sprintf("textures/%s.png", common.Materials[x]))
 		if err != nil {
@@ -24,8 +27,8 @@ func LoadTextures() *image.RGBA {
 		if err != nil {
 			panic(err)
 		}
-		sx := (x % 4) * 16
-		sy := (x / 4) * 16
+		sx := (x % textureColumns) * 16
+		sy := (x / textureColumns) * 16
 		r, g, b, _ := img.At(8, 8).RGBA()
 		common.MaterialColors[x] = mgl32.Vec3{float32(r) / 0xffff, float32(g) / 0xffff, float32(b) / 0xffff}
 		draw.Draw(rgba, image.Rect(sx, sy, sx+16, sy+16), img, image.Pt(0, 0), draw.Src)
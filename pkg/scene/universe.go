@@ -2,7 +2,6 @@ package scene
 
 import (
 	"math"
-	"net/rpc"
 
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/go-gl/glfw/v3.2/glfw"
@@ -15,15 +14,17 @@ type Universe struct {
 	Player          *common.Player
 	PlanetMap       map[int]*Planet
 	ConnectedPeople []*common.PlayerState
-	RPC             *rpc.Client
+	RPC             common.RPCClient
+	Chat            *ChatLog
 }
 
 // NewUniverse creates a new universe
-func NewUniverse(player *common.Player, rpc *rpc.Client) *Universe {
+func NewUniverse(player *common.Player, rpc common.RPCClient) *Universe {
 	u := Universe{}
 	u.Player = player
 	u.PlanetMap = make(map[int]*Planet)
 	u.RPC = rpc
+	u.Chat = &ChatLog{}
 	return &u
 }
 
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+// accountSaltSize is the number of random bytes hashed with each player's token
+const accountSaltSize = 16
+
+// accountMutex makes checking and registering an account one step,
+// so two clients joining with a new name at once cannot both register it
+var accountMutex = &sync.Mutex{}
+
+// registeredOnly returns whether only players with an account may join,
+// turned on with "registeredonly=true" in the server config
+func registeredOnly() bool {
+	return getconfig("registeredonly") == "true"
+}
+
+// hashToken returns the salted hash stored for a player's token
+func hashToken(salt []byte, token string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(token))
+	return h.Sum(nil)
+}
+
+// checkAccount returns an error if a player may not join with a name and token.
+// Unknown names are registered with their token unless the server only allows registered players.
+func checkAccount(name, token string) error {
+	if token == "" {
+		return errors.New("A player token is required")
+	}
+	accountMutex.Lock()
+	defer accountMutex.Unlock()
+	var salt, hash []byte
+	err := db.QueryRow("SELECT salt, hash FROM account WHERE name = ?", name).Scan(&salt, &hash)
+	if err == sql.ErrNoRows {
+		if registeredOnly() {
+			return errors.New("This server only allows registered players")
+		}
+		return createAccount(name, token)
+	}
+	checkErr(err)
+	if subtle.ConstantTimeCompare(hashToken(salt, token), hash) != 1 {
+		return errors.New("The name " + name + " is registered to another player")
+	}
+	return nil
+}
+
+// createAccount registers a name to a token
+func createAccount(name, token string) error {
+	salt := make([]byte, accountSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	_, err := db.Exec("INSERT INTO account VALUES (?, ?, ?)", name, salt, hashToken(salt, token))
+	return err
+}
+
+// registerAccount registers a name to a token for a player who does not have an account yet
+func registerAccount(name, token string) error {
+	accountMutex.Lock()
+	defer accountMutex.Unlock()
+	var count int
+	checkErr(db.QueryRow("SELECT COUNT(*) FROM account WHERE name = ?", name).Scan(&count))
+	if count > 0 {
+		return errors.New(name + " is already registered")
+	}
+	return createAccount(name, token)
+}
+
+// unregisterAccount frees a name, so the next player to join with it registers it
+// or, if the server only allows registered players, so no one can join with it
+func unregisterAccount(name string) error {
+	accountMutex.Lock()
+	defer accountMutex.Unlock()
+	res, err := db.Exec("DELETE FROM account WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New(name + " is not registered")
+	}
+	return nil
+}
+
+// bannedMessage is shown to banned players when no reason was given
+const bannedMessage = "You are banned from this server"
+
+// banReason returns why a player is banned, or "" if they are not
+func banReason(name string) string {
+	var reason string
+	err := db.QueryRow("SELECT reason FROM ban WHERE name = ?", name).Scan(&reason)
+	if err == sql.ErrNoRows {
+		return ""
+	}
+	checkErr(err)
+	if reason == "" {
+		reason = bannedMessage
+	}
+	return reason
+}
+
+// ban stops a player joining
+func ban(name, reason string) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO ban VALUES (?, ?)", name, reason)
+	return err
+}
+
+// unban lets a banned player join again
+func unban(name string) error {
+	res, err := db.Exec("DELETE FROM ban WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New(name + " is not banned")
+	}
+	return nil
+}
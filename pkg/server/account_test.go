@@ -0,0 +1,38 @@
+package server
+
+import "testing"
+
+func TestRegisterAndUnregisterCommands(t *testing.T) {
+	useTestWorld(t, "registeredonly=true;")
+	registerCommands()
+	api := newAPI()
+
+	if checkAccount("bob", "secret") == nil {
+		t.Fatal("an unregistered player joined a server that only allows registered players")
+	}
+	if out := api.runCommand("register bob secret"); out != "Registered bob" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if err := checkAccount("bob", "secret"); err != nil {
+		t.Fatalf("a registered player could not join: %v", err)
+	}
+	if checkAccount("bob", "guess") == nil {
+		t.Fatal("a player joined with another player's name")
+	}
+	if out := api.runCommand("register bob other"); out != "bob is already registered" {
+		t.Fatalf("registering a name twice gave %q", out)
+	}
+
+	if out := api.runCommand("unregister bob"); out != "Unregistered bob" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if checkAccount("bob", "secret") == nil {
+		t.Fatal("an unregistered player could still join")
+	}
+	if out := api.runCommand("unregister bob"); out != "bob is not registered" {
+		t.Fatalf("unregistering an unknown name gave %q", out)
+	}
+	if out := api.runCommand("register bob"); out != "Usage: "+commands["register"].usage {
+		t.Fatalf("a missing token gave %q", out)
+	}
+}
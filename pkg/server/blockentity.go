@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+var (
+	blockEntitiesMutex = &sync.Mutex{}
+	blockEntities      = make(map[common.PlanetCellIndex]*blockEntity)
+)
+
+// blockEntity is a loaded block entity along with the players viewing it
+type blockEntity struct {
+	common.BlockEntity
+	viewers map[string]bool
+}
+
+// blockEntityBreakRule returns what happens to block entity contents when the block is broken,
+// set with "blockentitybreak=keep" or "blockentitybreak=drop" in the server config
+func blockEntityBreakRule() int {
+	if getconfig("blockentitybreak") == "keep" {
+		return common.KeepContents
+	}
+	return common.DropContents
+}
+
+// getBlockEntity loads the block entity at a cell, creating it if the cell's material needs one.
+// The caller must hold blockEntitiesMutex.
+func getBlockEntity(ind common.PlanetCellIndex) *blockEntity {
+	planet := universe.PlanetMap[ind.Planet]
+	if planet == nil {
+		return nil
+	}
+	cell := planet.CellIndexToCell(ind.CellIndex)
+	if cell == nil || !common.HasBlockEntity(cell.Material) {
+		return nil
+	}
+	e := blockEntities[ind]
+	if e != nil && e.Material == cell.Material {
+		return e
+	}
+	e = &blockEntity{viewers: make(map[string]bool)}
+	rows, err := db.Query("SELECT data FROM blockentity WHERE planet = ? AND lon = ? AND lat = ? AND alt = ?", ind.Planet, ind.Lon, ind.Lat, ind.Alt)
+	checkErr(err)
+	if rows.Next() {
+		var data []byte
+		checkErr(rows.Scan(&data))
+		dec := gob.NewDecoder(bytes.NewBuffer(data))
+		checkErr(dec.Decode(&e.BlockEntity))
+	}
+	rows.Close()
+	if e.Material != cell.Material {
+		e.BlockEntity = *common.NewBlockEntity(ind, cell.Material)
+		saveBlockEntity(e)
+	}
+	blockEntities[ind] = e
+	return e
+}
+
+func saveBlockEntity(e *blockEntity) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	checkErr(enc.Encode(e.BlockEntity))
+	defer observeWrite("blockentity", time.Now())
+	_, err := db.Exec("INSERT OR REPLACE INTO blockentity VALUES (?, ?, ?, ?, ?)", e.Planet, e.Lon, e.Lat, e.Alt, buf.Bytes())
+	checkErr(err)
+}
+
+// removeBlockEntity deletes the block entity at a cell, closing it for anyone viewing it,
+// and returns its contents. The caller must hold blockEntitiesMutex.
+func (api *API) removeBlockEntity(ind common.PlanetCellIndex) []common.Slot {
+	e := blockEntities[ind]
+	delete(blockEntities, ind)
+	_, err := db.Exec("DELETE FROM blockentity WHERE planet = ? AND lon = ? AND lat = ? AND alt = ?", ind.Planet, ind.Lon, ind.Lat, ind.Alt)
+	checkErr(err)
+	if e == nil {
+		return nil
+	}
+	e.Material = common.Air
+	api.updateBlockEntityViewers(e)
+	return e.Slots
+}
+
+// breakBlockEntity removes the block entity of a cell being broken or replaced, returning its contents
+// to drop. If the server keeps contents, a block entity that is not empty is left in place and an error
+// returned. The caller must hold blockEntitiesMutex.
+func (api *API) breakBlockEntity(ind common.PlanetCellIndex) ([]common.Slot, error) {
+	e := getBlockEntity(ind)
+	if e != nil && blockEntityBreakRule() == common.KeepContents && !e.Empty() {
+		return nil, errors.New("Block must be emptied before it can be broken")
+	}
+	return api.removeBlockEntity(ind), nil
+}
+
+// updateBlockEntityViewers sends the current state of a block entity to every player viewing it
+func (api *API) updateBlockEntityViewers(e *blockEntity) {
+	entity := e.BlockEntity
+	entity.Slots = append([]common.Slot{}, e.Slots...)
+	for name := range e.viewers {
+		api.sendTo(name, "API.UpdateBlockEntity", &entity)
+	}
+}
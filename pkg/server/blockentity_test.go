@@ -0,0 +1,116 @@
+package server
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// useTestWorld runs the rest of a test in a temporary directory with the given server config
+// and an empty world database
+func useTestWorld(t *testing.T, config string) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := t.TempDir()
+	if err := os.Chdir(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("server.buildorb", []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err = sql.Open("sqlite3", filepath.Join(tmp, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTables()
+	t.Cleanup(func() {
+		db.Close()
+		os.Chdir(dir)
+	})
+}
+
+// chestBesideExplosive sets up a planet with a chest holding some stone next to an explosive,
+// returning the planet and the indices of the explosive and the chest
+func chestBesideExplosive(t *testing.T) (*common.Planet, common.PlanetCellIndex, common.PlanetCellIndex) {
+	planet := common.NewPlanet(common.PlanetState{ID: 0, Radius: 32, AltCells: 32}, nil, nil)
+	universe = &common.Universe{PlanetMap: map[int]*common.Planet{0: planet}}
+	// Near the equator, where every cell index is its own cell
+	lat := planet.LatCells / 2
+	explosive := common.PlanetCellIndex{CellIndex: common.CellIndex{Lon: 1, Lat: lat, Alt: 10}}
+	chest := common.PlanetCellIndex{CellIndex: common.CellIndex{Lon: 1, Lat: lat, Alt: 11}}
+	planet.CellIndexToCell(explosive.CellIndex).Material = common.Explosive
+	planet.CellIndexToCell(chest.CellIndex).Material = common.Chest
+
+	blockEntitiesMutex.Lock()
+	e := getBlockEntity(chest)
+	e.Slots[0] = common.Slot{Material: common.Stone, Amount: 3}
+	saveBlockEntity(e)
+	blockEntitiesMutex.Unlock()
+	return planet, explosive, chest
+}
+
+func TestExplosionKeepsChestWithContents(t *testing.T) {
+	useTestWorld(t, "blockentitybreak=keep;")
+	planet, explosive, chest := chestBesideExplosive(t)
+	api := newAPI()
+	_, client := newTestClient(t)
+	if _, err := api.join(client, common.PlayerState{Name: "alice"}, common.Capabilities, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	api.explode("alice", explosive)
+	if planet.CellIndexToCell(explosive.CellIndex).Material != common.Air {
+		t.Fatalf("expected the explosive to be gone")
+	}
+	if planet.CellIndexToCell(chest.CellIndex).Material != common.Chest {
+		t.Fatalf("expected the chest to be left standing")
+	}
+	blockEntitiesMutex.Lock()
+	e := getBlockEntity(chest)
+	blockEntitiesMutex.Unlock()
+	if e == nil || e.Slots[0].Amount != 3 {
+		t.Fatalf("expected the chest to keep its contents, got %+v", e)
+	}
+}
+
+func TestExplosionDropsChestContents(t *testing.T) {
+	useTestWorld(t, "blockentitybreak=drop;")
+	planet, explosive, chest := chestBesideExplosive(t)
+	api := newAPI()
+	_, client := newTestClient(t)
+	s, err := api.join(client, common.PlayerState{Name: "alice"}, common.Capabilities, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api.explode("alice", explosive)
+	if planet.CellIndexToCell(chest.CellIndex).Material != common.Air {
+		t.Fatalf("expected the chest to be destroyed")
+	}
+	if !s.takeItems(common.Slot{Material: common.Stone, Amount: 3}) {
+		t.Fatalf("expected the chest contents to go to the player who set off the explosion")
+	}
+}
+
+func TestExplosionDestroysChestAfterTriggerPlayerLeft(t *testing.T) {
+	useTestWorld(t, "blockentitybreak=drop;")
+	planet, explosive, chest := chestBesideExplosive(t)
+	api := newAPI()
+
+	api.explode("gone", explosive)
+	if planet.CellIndexToCell(chest.CellIndex).Material != common.Air {
+		t.Fatalf("expected the chest to be destroyed")
+	}
+	blockEntitiesMutex.Lock()
+	e := getBlockEntity(chest)
+	blockEntitiesMutex.Unlock()
+	if e != nil && !e.Empty() {
+		t.Fatalf("expected the chest contents to be lost, got %+v", e)
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// chatHistorySize is how many public messages are kept and sent to players when they join
+const chatHistorySize = 50
+
+var (
+	chatMutex   = &sync.Mutex{}
+	chatHistory []common.ChatMessage
+)
+
+// rememberChat adds a public message to the history sent to players who join later
+func rememberChat(msg common.ChatMessage) {
+	chatMutex.Lock()
+	defer chatMutex.Unlock()
+	chatHistory = append(chatHistory, msg)
+	if len(chatHistory) > chatHistorySize {
+		chatHistory = chatHistory[len(chatHistory)-chatHistorySize:]
+	}
+}
+
+// sendChatHistory sends the recent public messages to a player who joined
+func sendChatHistory(s *session) {
+	chatMutex.Lock()
+	history := append([]common.ChatMessage{}, chatHistory...)
+	chatMutex.Unlock()
+	if len(history) > 0 {
+		s.out.send("API.ChatHistory", history)
+	}
+}
+
+// Chat sends a message from the caller to everyone. "/msg <name> <text>" sends it to one player,
+// and other texts starting with a slash run admin commands, which only operators may run.
+func (api *API) Chat(text *string, ret *bool) error {
+	if api.session == nil {
+		return errors.New("Not joined")
+	}
+	t := strings.TrimSpace(*text)
+	if t == "" {
+		return errors.New("Nothing to send")
+	}
+	if len(t) > common.MaxChatLength {
+		return errors.New("Message is too long")
+	}
+	*ret = true
+	if strings.HasPrefix(t, "/") {
+		api.chatCommand(strings.TrimPrefix(t, "/"))
+		return nil
+	}
+	msg := common.ChatMessage{From: api.caller(), Channel: common.ChatPublic, Time: time.Now(), Text: t}
+	rememberChat(msg)
+	api.broadcast("API.Chat", &msg)
+	return nil
+}
+
+// chatCommand runs a slash command typed in chat, answering only the caller
+func (api *API) chatCommand(line string) {
+	name := api.caller()
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return
+	}
+	switch args[0] {
+	case "msg", "tell", "w":
+		if len(args) < 3 {
+			api.session.tell("Usage: /msg <name> <text>")
+			return
+		}
+		target := api.sessions.byName(args[1])
+		if target == nil {
+			api.session.tell(args[1] + " is not connected")
+			return
+		}
+		text := strings.Join(args[2:], " ")
+		msg := common.ChatMessage{From: name, To: target.Name(), Channel: common.ChatPrivate, Time: time.Now(), Text: text}
+		target.out.send("API.Chat", &msg)
+		if target != api.session {
+			api.session.out.send("API.Chat", &msg)
+		}
+		return
+	}
+	if !isOperator(name) {
+		api.session.tell("You are not allowed to run commands")
+		return
+	}
+	logged := line
+	if args[0] == "register" && len(args) > 2 {
+		// Keep the account token out of the log
+		logged = strings.Join(args[:2], " ") + " ..."
+	}
+	log.Printf("%v ran /%v", name, logged)
+	if out := api.runCommand(line); out != "" {
+		api.session.tell(out)
+	}
+}
+
+// tell sends a server message to one player
+func (s *session) tell(text string) {
+	msg := common.ChatMessage{Channel: common.ChatServer, To: s.Name(), Time: time.Now(), Text: text}
+	s.out.send("API.Chat", &msg)
+}
+
+// announce sends a server message to everyone
+func (api *API) announce(text string) {
+	msg := common.ChatMessage{Channel: common.ChatServer, Time: time.Now(), Text: text}
+	rememberChat(msg)
+	api.broadcast("API.Chat", &msg)
+}
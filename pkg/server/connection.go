@@ -0,0 +1,137 @@
+package server
+
+import (
+	"log"
+	"net"
+	"net/rpc"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// handleConnection sets up a client connection and keeps its session registered until the
+// connection closes. Clients that do not finish connecting in time are dropped.
+func (api *API) handleConnection(conn net.Conn) {
+	addr := conn.RemoteAddr()
+
+	// Set up server side of yamux, giving the client a deadline to say hello and open its stream
+	conn.SetDeadline(time.Now().Add(common.HandshakeTimeout))
+	mux, e := yamux.Server(conn, common.MuxConfig())
+	if e != nil {
+		log.Printf("%v: %v", addr, e)
+		conn.Close()
+		return
+	}
+
+	// The first stream is the hello exchange
+	helloStream, e := mux.Accept()
+	if e != nil {
+		log.Printf("%v: %v", addr, e)
+		mux.Close()
+		return
+	}
+	hello, reply, e := common.ReceiveHello(helloStream, api.checkHello)
+	helloStream.Close()
+	if e != nil {
+		log.Printf("%v: hello error: %v", addr, e)
+		mux.Close()
+		return
+	}
+	if hello.StatusOnly || !reply.Accepted {
+		if !hello.StatusOnly {
+			log.Printf("%v: rejected %v (protocol %v, build %v): %v", addr, hello.Name, hello.ProtocolVersion, hello.Build, reply.Message)
+		}
+
+		// Give the client a moment to read the reply before hanging up
+		select {
+		case <-mux.CloseChan():
+		case <-time.After(time.Second):
+		}
+		mux.Close()
+		return
+	}
+
+	muxConn, e := mux.Accept()
+	if e != nil {
+		log.Printf("%v: %v", addr, e)
+		mux.Close()
+		return
+	}
+
+	// Set up stream back to client
+	stream, e := mux.Open()
+	if e != nil {
+		log.Printf("%v: %v", addr, e)
+		mux.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	crpc := rpc.NewClient(stream)
+
+	// A player resuming after their connection dropped carries on where they were,
+	// and otherwise anything kept from when they last left is forgotten
+	var left *leftSession
+	if hello.Resume {
+		left = api.takeOver(hello.Name)
+	} else {
+		takeLeftSession(hello.Name)
+	}
+	state := common.PlayerState{Name: hello.Name}
+	if left != nil {
+		state = left.state
+	}
+	s, e := api.join(crpc, state, reply.Capabilities, mux)
+	if e != nil {
+		log.Printf("%v: %v", addr, e)
+		mux.Close()
+		return
+	}
+	if left != nil {
+		s.restore(left)
+		log.Printf("%v resumed their session", hello.Name)
+	} else {
+		loadPlayer(s)
+	}
+	s.sendPlayer()
+	srpc := rpc.NewServer()
+	srpc.Register(api.forSession(s))
+	go srpc.ServeCodec(newTimedCodec(muxConn, apiMethods))
+	<-mux.CloseChan()
+	api.sessions.remove(s.id)
+
+	// Once the world is being saved to stop, everyone is saved with it
+	if !isSaving() {
+		savePlayer(s)
+	}
+}
+
+// checkHello answers a client's hello, accepting it only if the player's token
+// matches their account and they are not banned or, unless resuming, already playing.
+// A hello that only asks for the status is answered with it, whatever the client's version.
+func (api *API) checkHello(hello common.Hello) common.HelloReply {
+	if hello.StatusOnly {
+		return common.HelloReply{
+			Accepted:        true,
+			ProtocolVersion: common.ProtocolVersion,
+			Build:           common.Build,
+			Status:          api.status(),
+		}
+	}
+	reply := common.CheckHello(hello)
+	reply.Instance = startTime.UnixNano()
+	if !reply.Accepted {
+		return reply
+	}
+	if reason := banReason(hello.Name); reason != "" {
+		reply.Accepted = false
+		reply.Message = reason
+	} else if err := checkAccount(hello.Name, hello.Token); err != nil {
+		reply.Accepted = false
+		reply.Message = err.Error()
+	} else if !hello.Resume && api.sessions.byName(hello.Name) != nil {
+		reply.Accepted = false
+		reply.Message = hello.Name + " is already playing on this server"
+	}
+	return reply
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// command is an admin command run from the console or by an operator in chat
+type command struct {
+	usage string
+	help  string
+	run   func(api *API, args []string) (string, error)
+}
+
+var commands = make(map[string]*command)
+
+// registerCommand adds an admin command
+func registerCommand(name, usage, help string, run func(api *API, args []string) (string, error)) {
+	commands[name] = &command{usage: usage, help: help, run: run}
+}
+
+// registerCommands registers the admin commands
+func registerCommands() {
+	registerCommand("help", "help", "List the commands", helpCommand)
+	registerCommand("list", "list", "List the connected players", listCommand)
+	registerCommand("kick", "kick <name> [reason]", "Disconnect a player", kickCommand)
+	registerCommand("ban", "ban <name> [reason]", "Disconnect a player and stop them joining again", banCommand)
+	registerCommand("unban", "unban <name>", "Let a banned player join again", unbanCommand)
+	registerCommand("register", "register <name> <token>", "Give a player an account so they can join", registerAccountCommand)
+	registerCommand("unregister", "unregister <name>", "Remove a player's account", unregisterAccountCommand)
+	registerCommand("say", "say <text>", "Send a text to everyone", sayCommand)
+	registerCommand("tp", "tp <name> <player|planet ID>", "Move a player to another player or to a planet's spawn", tpCommand)
+	registerCommand("gamemode", "gamemode <name> <creative|survival>", "Set a player's game mode", gameModeCommand)
+	registerCommand("save", "save", "Save the world now", saveCommand)
+	registerCommand("stop", "stop [seconds]", "Count down, then save the world and stop the server", stopCommand)
+	registerCommand("planets", "planets", "List the planets", planetsCommand)
+	registerCommand("lan", "lan [port]", "Let other players join a single-player world over the network", lanCommand)
+}
+
+// runCommand runs a command line, returning what to show whoever typed it
+func (api *API) runCommand(line string) string {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return ""
+	}
+	c := commands[args[0]]
+	if c == nil {
+		return fmt.Sprintf("Unknown command %v, try help", args[0])
+	}
+	out, err := c.run(api, args[1:])
+	if err != nil {
+		return err.Error()
+	}
+	return out
+}
+
+// runConsole reads commands a line at a time and writes their output, until the input closes
+func (api *API) runConsole(r io.Reader, w io.Writer) {
+	scanner := bufio.NewScanner(r)
+	fmt.Fprint(w, "> ")
+	for scanner.Scan() {
+		if out := api.runCommand(scanner.Text()); out != "" {
+			fmt.Fprintln(w, out)
+		}
+		fmt.Fprint(w, "> ")
+	}
+}
+
+// listenConsole serves the console on a Unix socket, set with "consolesocket=<path>"
+// in the server config. Only the user running the server may connect.
+func (api *API) listenConsole() {
+	path := getconfig("consolesocket")
+	if path == "" {
+		return
+	}
+	os.Remove(path)
+	sock, err := net.Listen("unix", path)
+	if err != nil {
+		log.Printf("Console socket error: %v", err)
+		return
+	}
+	checkErr(os.Chmod(path, 0600))
+	log.Printf("Console listening on %v", path)
+	go func() {
+		for {
+			conn, err := sock.Accept()
+			if err != nil {
+				log.Printf("Console socket error: %v", err)
+				return
+			}
+			go func() {
+				api.runConsole(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+}
+
+// isOperator returns whether a player may run admin commands from chat,
+// set with "ops=<name>,<name>" in the server config. The owner of a single-player world always may.
+func isOperator(name string) bool {
+	if name != "" && name == localOwner {
+		return true
+	}
+	for _, op := range strings.Split(getconfig("ops"), ",") {
+		if strings.TrimSpace(op) == name && name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// playerArg returns the connected session for a command's player name
+func (api *API) playerArg(args []string, count int, usage string) (*session, error) {
+	if len(args) < count {
+		return nil, errors.New("Usage: " + usage)
+	}
+	s := api.sessions.byName(args[0])
+	if s == nil {
+		return nil, errors.New(args[0] + " is not connected")
+	}
+	return s, nil
+}
+
+func helpCommand(api *API, args []string) (string, error) {
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := []string{}
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%v - %v", commands[name].usage, commands[name].help))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func listCommand(api *API, args []string) (string, error) {
+	lines := []string{}
+	for _, s := range api.sessions.all() {
+		state := s.State()
+		p := state.Position
+		lines = append(lines, fmt.Sprintf("%v on planet %v at %.0f %.0f %.0f", state.Name, state.Planet, p[0], p[1], p[2]))
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf("%v connected\n%v", len(lines), strings.Join(lines, "\n")), nil
+}
+
+// kick tells a player why they are being disconnected, then disconnects them
+func (s *session) kick(reason string) {
+	log.Printf("Kicking %v: %v", s.Name(), reason)
+	s.out.sendAndClose("API.Disconnect", reason)
+}
+
+func kickCommand(api *API, args []string) (string, error) {
+	s, err := api.playerArg(args, 1, commands["kick"].usage)
+	if err != nil {
+		return "", err
+	}
+	reason := "Kicked from the server"
+	if len(args) > 1 {
+		reason = strings.Join(args[1:], " ")
+	}
+	s.kick(reason)
+	return "Kicked " + s.Name(), nil
+}
+
+func banCommand(api *API, args []string) (string, error) {
+	if len(args) < 1 {
+		return "", errors.New("Usage: " + commands["ban"].usage)
+	}
+	reason := bannedMessage
+	if len(args) > 1 {
+		reason = strings.Join(args[1:], " ")
+	}
+	if err := ban(args[0], reason); err != nil {
+		return "", err
+	}
+	if s := api.sessions.byName(args[0]); s != nil {
+		s.kick(reason)
+	}
+	return "Banned " + args[0], nil
+}
+
+func unbanCommand(api *API, args []string) (string, error) {
+	if len(args) < 1 {
+		return "", errors.New("Usage: " + commands["unban"].usage)
+	}
+	if err := unban(args[0]); err != nil {
+		return "", err
+	}
+	return "Unbanned " + args[0], nil
+}
+
+func registerAccountCommand(api *API, args []string) (string, error) {
+	if len(args) != 2 {
+		return "", errors.New("Usage: " + commands["register"].usage)
+	}
+	if err := registerAccount(args[0], args[1]); err != nil {
+		return "", err
+	}
+	return "Registered " + args[0], nil
+}
+
+func unregisterAccountCommand(api *API, args []string) (string, error) {
+	if len(args) < 1 {
+		return "", errors.New("Usage: " + commands["unregister"].usage)
+	}
+	if err := unregisterAccount(args[0]); err != nil {
+		return "", err
+	}
+	return "Unregistered " + args[0], nil
+}
+
+func sayCommand(api *API, args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("Usage: " + commands["say"].usage)
+	}
+	text := strings.Join(args, " ")
+	api.announce(text)
+	return "[Server] " + text, nil
+}
+
+func tpCommand(api *API, args []string) (string, error) {
+	s, err := api.playerArg(args, 2, commands["tp"].usage)
+	if err != nil {
+		return "", err
+	}
+	var tp common.TeleportArgs
+	if target := api.sessions.byName(args[1]); target != nil {
+		state := target.State()
+		tp = common.TeleportArgs{Planet: state.Planet, Position: state.Position}
+	} else if id, err := strconv.Atoi(args[1]); err == nil && universe.PlanetMap[id] != nil {
+		tp = common.TeleportArgs{Planet: id, Spawn: true}
+	} else {
+		return "", errors.New("No player or planet " + args[1])
+	}
+	s.out.send("API.Teleport", &tp)
+	return fmt.Sprintf("Teleported %v to %v", s.Name(), args[1]), nil
+}
+
+func gameModeCommand(api *API, args []string) (string, error) {
+	s, err := api.playerArg(args, 2, commands["gamemode"].usage)
+	if err != nil {
+		return "", err
+	}
+	mode := common.Survival
+	switch args[1] {
+	case "creative":
+		mode = common.Creative
+	case "survival":
+	default:
+		return "", errors.New("Usage: " + commands["gamemode"].usage)
+	}
+	s.setGameMode(mode)
+	s.out.send("API.SetGameMode", &mode)
+	return fmt.Sprintf("Set %v to %v", s.Name(), args[1]), nil
+}
+
+func saveCommand(api *API, args []string) (string, error) {
+	if isSaving() {
+		return "", errors.New("The server is stopping and saves as it stops")
+	}
+	autosave(api, 0)
+	return "Saved", nil
+}
+
+func stopCommand(api *API, args []string) (string, error) {
+	countdown := shutdownCountdown()
+	if len(args) > 0 {
+		seconds, err := strconv.Atoi(args[0])
+		if err != nil || seconds < 0 {
+			return "", errors.New("Usage: " + commands["stop"].usage)
+		}
+		countdown = time.Duration(seconds) * time.Second
+	}
+	if isStopping() {
+		return "", errors.New("The server is already stopping")
+	}
+	if localOwner != "" {
+		return "", errors.New("Close the game to stop a single-player world")
+	}
+	go api.shutdown("Server is stopping", countdown)
+	return fmt.Sprintf("Stopping in %v", countdown), nil
+}
+
+func planetsCommand(api *API, args []string) (string, error) {
+	ids := []int{}
+	for id := range universe.PlanetMap {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	lines := []string{}
+	for _, id := range ids {
+		p := universe.PlanetMap[id]
+		lines = append(lines, fmt.Sprintf("%v %v (%v), radius %v", id, p.Name, p.GeneratorType, p.Radius))
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// serverName is the name the server is shown by, set with "name=<text>" in the server config
+// and defaulting to the world's name
+func serverName() string {
+	if name := getconfig("name"); name != "" {
+		return name
+	}
+	return worldName
+}
+
+// announceLAN broadcasts the server on the local network until stop is closed,
+// turned on with "lanannounce=true" in the server config
+func (api *API) announceLAN(port int, stop chan bool) {
+	if getconfig("lanannounce") != "true" {
+		return
+	}
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4bcast, Port: common.DiscoveryPort})
+	if err != nil {
+		log.Printf("LAN announce error: %v", err)
+		return
+	}
+	defer conn.Close()
+	log.Printf("Announcing on the local network")
+	for {
+		a := common.Announcement{
+			Name:            serverName(),
+			World:           worldName,
+			Players:         api.sessions.count(),
+			Port:            port,
+			ProtocolVersion: common.ProtocolVersion,
+			Build:           common.Build,
+		}
+		data, err := a.Encode()
+		if err == nil {
+			_, err = conn.Write(data)
+		}
+		if err != nil {
+			log.Printf("LAN announce error: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(common.DiscoveryInterval):
+		}
+	}
+}
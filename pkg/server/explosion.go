@@ -0,0 +1,83 @@
+package server
+
+import (
+	"log"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// explosiveFuse is how many seconds an explosive takes to go off after it is triggered
+const explosiveFuse = 1.5
+
+// explode resolves an explosion at a cell along with every explosive it sets off,
+// sends all the changed cells to clients in one batch, and damages players in range.
+// Block entities caught in it follow the server's rule: those that are not empty are left standing
+// if contents are kept, otherwise their contents go to the player who set off the explosion, or are
+// lost if that player has left.
+func (api *API) explode(from string, start common.PlanetCellIndex) {
+	editMutex.Lock()
+	defer editMutex.Unlock()
+	planet := universe.PlanetMap[start.Planet]
+	if planet == nil {
+		return
+	}
+	cell := planet.CellIndexToCell(start.CellIndex)
+	if cell == nil || !common.IsExplosive(cell.Material) {
+		return
+	}
+	removed := map[*common.Cell]bool{cell: true}
+	removedIndices := []common.CellIndex{start.CellIndex}
+	var centers []common.CellIndex
+	queue := []common.CellIndex{start.CellIndex}
+	for len(queue) > 0 {
+		center := queue[0]
+		queue = queue[1:]
+		centers = append(centers, center)
+		for _, ind := range planet.BlastCells(center) {
+			cell := planet.CellIndexToCell(ind)
+			if removed[cell] {
+				continue
+			}
+			removed[cell] = true
+			removedIndices = append(removedIndices, ind)
+			if common.IsExplosive(cell.Material) {
+				queue = append(queue, ind)
+			}
+		}
+	}
+
+	recipient := api.sessions.byName(from)
+	changes := make([]common.CellChange, 0, len(removedIndices))
+	blockEntitiesMutex.Lock()
+	for _, ind := range removedIndices {
+		cell := planet.CellIndexToCell(ind)
+		if common.HasBlockEntity(cell.Material) {
+			pind := common.PlanetCellIndex{Planet: start.Planet, CellIndex: ind}
+			contents, err := api.breakBlockEntity(pind)
+			if err != nil {
+				continue
+			}
+			if recipient != nil {
+				recipient.addItems(contents...)
+			}
+		}
+		changes = append(changes, common.CellChange{Index: ind, Material: common.Air})
+	}
+	blockEntitiesMutex.Unlock()
+	api.changeCells(planet, changes)
+
+	for _, s := range api.sessions.all() {
+		state := s.State()
+		if state.Planet != start.Planet {
+			continue
+		}
+		amount := 0
+		for _, center := range centers {
+			amount += planet.BlastDamage(center, state.Position)
+		}
+		if amount > 0 {
+			api.hitPlayer(&common.HitPlayerArgs{From: from, Target: state.Name, Amount: amount})
+		}
+	}
+	log.Printf("%v set off %v explosions changing %v cells", from, len(centers), len(changes))
+}
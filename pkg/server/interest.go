@@ -0,0 +1,61 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// playerRange is how close another player on the same planet must be for their state
+// to be sent to a client, set with "playerrange=<distance>" in the server config.
+// Zero sends everyone on the same planet.
+var playerRange float32
+
+// loadPlayerRange reads the player range from the server config
+func loadPlayerRange() {
+	r, err := strconv.ParseFloat(getconfig("playerrange"), 32)
+	if err == nil && r > 0 {
+		playerRange = float32(r)
+	}
+}
+
+// sees returns whether the client should be sent another person's state
+func (s *session) sees(state *common.PlayerState) bool {
+	own := s.State()
+	if own.Planet != state.Planet {
+		return false
+	}
+	return playerRange <= 0 || own.Position.Sub(state.Position).Len() <= playerRange
+}
+
+// setVisible records whether the client is being sent a person's state,
+// returning whether that changed
+func (s *session) setVisible(name string, visible bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.visible[name] == visible {
+		return false
+	}
+	if visible {
+		s.visible[name] = true
+	} else {
+		delete(s.visible, name)
+	}
+	return true
+}
+
+// sendState sends a person's state to everyone who can see them, and tells
+// clients that can no longer see them to forget them
+func (api *API) sendState(from *session, state *common.PlayerState) {
+	for _, s := range api.sessions.all() {
+		if s == from {
+			continue
+		}
+		if s.sees(state) {
+			s.setVisible(state.Name, true)
+			s.out.sendState(state)
+		} else if s.setVisible(state.Name, false) {
+			s.out.send("API.PersonDisconnected", state.Name)
+		}
+	}
+}
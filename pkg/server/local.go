@@ -0,0 +1,83 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync/atomic"
+)
+
+// localOwner is the player who started a single-player world, who may run commands in it
+var localOwner string
+
+// Local is a world served inside the game for single-player. Its players connect in memory,
+// and it listens on no port until it is opened to the LAN.
+type Local struct {
+	api *API
+}
+
+// StartLocal opens a world and serves it in-process, letting its owner run commands
+func StartLocal(name string, seed int, owner string) *Local {
+	api := openWorld(name, seed)
+	localOwner = owner
+	return &Local{api: api}
+}
+
+// Connect returns a new in-memory connection to the world
+func (l *Local) Connect() net.Conn {
+	serverEnd, clientEnd := net.Pipe()
+	go l.api.handleConnection(serverEnd)
+	return clientEnd
+}
+
+// OpenToLAN lets other players join the world over TCP on a port
+func (l *Local) OpenToLAN(port int) error {
+	return l.api.openToLAN(port)
+}
+
+// Stop disconnects everyone, saves the world and closes it
+func (l *Local) Stop() error {
+	if !atomic.CompareAndSwapInt32(&stopping, 0, 1) {
+		return errors.New("The world is already stopped")
+	}
+	if listener != nil {
+		listener.Close()
+	}
+	if l.api.stopWorld("The world was closed") != 0 {
+		return errors.New("The world could not be saved")
+	}
+	return nil
+}
+
+// openToLAN starts listening for players on a port, unless the server already is
+func (api *API) openToLAN(port int) error {
+	if listener != nil {
+		return errors.New("The world is already open to other players")
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf(":%v", port))
+	if err != nil {
+		return err
+	}
+	listener = l
+	log.Printf("Open to LAN on port %v", port)
+	go api.accept(l)
+	return nil
+}
+
+// lanCommand opens a single-player world to other players on the network
+func lanCommand(api *API, args []string) (string, error) {
+	port := 5555
+	if len(args) > 0 {
+		var err error
+		port, err = strconv.Atoi(args[0])
+		if err != nil || port <= 0 || port > 65535 {
+			return "", errors.New("Usage: lan [port]")
+		}
+	}
+	if err := api.openToLAN(port); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Open to LAN on port %v", port), nil
+}
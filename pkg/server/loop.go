@@ -0,0 +1,122 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Simulation timing: the server runs tickRate ticks per second, skips ahead when it falls
+// more than maxTickLag behind, and logs tick timing every metricsInterval
+const (
+	tickRate        = 20
+	tickDuration    = time.Second / tickRate
+	maxTickLag      = time.Second
+	metricsInterval = time.Minute
+)
+
+// system is a piece of server simulation run every few ticks
+type system struct {
+	name  string
+	every int
+	run   func(api *API, seconds float64)
+}
+
+var systems []*system
+
+// registerSystem adds a system that runs every given number of ticks.
+// It is passed the simulated seconds since it last ran.
+func registerSystem(name string, every int, run func(api *API, seconds float64)) {
+	if every < 1 {
+		every = 1
+	}
+	systems = append(systems, &system{name: name, every: every, run: run})
+}
+
+// tickMetrics holds timing for simulation ticks
+type tickMetrics struct {
+	mutex    sync.Mutex
+	Ticks    int64
+	Overruns int64
+	Skipped  int64
+	Last     time.Duration
+	LastTick time.Time
+	Max      time.Duration
+	Total    time.Duration
+	Systems  map[string]time.Duration
+}
+
+var metrics = &tickMetrics{Systems: make(map[string]time.Duration)}
+
+func (m *tickMetrics) record(elapsed time.Duration, systemTimes map[string]time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Ticks++
+	m.Last = elapsed
+	m.LastTick = time.Now()
+	m.Total += elapsed
+	if elapsed > m.Max {
+		m.Max = elapsed
+	}
+	if elapsed > tickDuration {
+		m.Overruns++
+	}
+	for name, d := range systemTimes {
+		m.Systems[name] += d
+	}
+}
+
+// logAndReset logs the tick timing since the last call and starts counting again
+func (m *tickMetrics) logAndReset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.Ticks == 0 {
+		return
+	}
+	log.Printf("%v ticks, average %v, max %v, %v overran, %v skipped", m.Ticks, m.Total/time.Duration(m.Ticks), m.Max, m.Overruns, m.Skipped)
+	for name, d := range m.Systems {
+		log.Printf("  %v: average %v", name, d/time.Duration(m.Ticks))
+	}
+	m.Ticks, m.Overruns, m.Skipped = 0, 0, 0
+	m.Max, m.Total = 0, 0
+	m.Systems = make(map[string]time.Duration)
+}
+
+// runLoop runs the registered systems at a fixed rate until the server starts saving the world to stop
+func (api *API) runLoop() {
+	var tick int64
+	next := time.Now()
+	lastMetrics := next
+	for !isSaving() {
+		systemTimes := make(map[string]time.Duration)
+		start := time.Now()
+		for _, s := range systems {
+			if tick%int64(s.every) != 0 {
+				continue
+			}
+			systemStart := time.Now()
+			s.run(api, float64(s.every)*tickDuration.Seconds())
+			systemTimes[s.name] = time.Since(systemStart)
+		}
+		metrics.record(time.Since(start), systemTimes)
+		tick++
+
+		if time.Since(lastMetrics) > metricsInterval {
+			lastMetrics = time.Now()
+			metrics.logAndReset()
+		}
+
+		next = next.Add(tickDuration)
+		wait := time.Until(next)
+		if wait > 0 {
+			time.Sleep(wait)
+		} else if -wait > maxTickLag {
+			skipped := int64(-wait / tickDuration)
+			metrics.mutex.Lock()
+			metrics.Skipped += skipped
+			metrics.mutex.Unlock()
+			next = time.Now()
+		}
+	}
+	close(loopStopped)
+}
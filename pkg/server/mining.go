@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// miningTolerance is the fraction of a material's hardness that mining must take,
+// leaving room for network delay between starting and finishing
+const miningTolerance = 0.8
+
+var (
+	miningMutex  = &sync.Mutex{}
+	miningStarts = make(map[string]miningStart)
+)
+
+// miningStart is the cell a player started mining and when
+type miningStart struct {
+	index common.PlanetCellIndex
+	time  time.Time
+}
+
+// startMining records that a player started mining a cell
+func startMining(name string, ind common.PlanetCellIndex) {
+	miningMutex.Lock()
+	miningStarts[name] = miningStart{index: ind, time: time.Now()}
+	miningMutex.Unlock()
+}
+
+// stopMining forgets the cell a player was mining
+func stopMining(name string) {
+	miningMutex.Lock()
+	delete(miningStarts, name)
+	miningMutex.Unlock()
+}
+
+// checkMining returns an error if a survival player could not have mined a cell of a material
+// since the server saw them start mining it. The time the client claims is not trusted.
+func checkMining(args *common.BreakCellArgs, material int) error {
+	miningMutex.Lock()
+	start, ok := miningStarts[args.From]
+	delete(miningStarts, args.From)
+	miningMutex.Unlock()
+	required := float64(common.MaterialHardness[material]) * miningTolerance
+	if required <= 0 {
+		return nil
+	}
+	if !ok || start.index != args.PlanetCellIndex || time.Since(start.time).Seconds() < required {
+		return errors.New("Cell was not mined long enough")
+	}
+	return nil
+}
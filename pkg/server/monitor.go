@@ -0,0 +1,280 @@
+package server
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/rpc"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// healthTickAge is how long the simulation loop may go without a tick before the server counts as unhealthy
+const healthTickAge = 5 * time.Second
+
+// latencyBuckets are the upper bounds in seconds of the latency histograms
+var latencyBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// Latencies reported by the metrics endpoint
+var (
+	rpcLatency      = newHistograms("method")
+	outboundLatency = newHistograms("method")
+	chunkLoadTime   = newHistograms("source")
+	dbWriteTime     = newHistograms("table")
+)
+
+// histogram counts observations into latency buckets
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// histograms holds a histogram for each value of a label, safe for use from any goroutine
+type histograms struct {
+	mutex   sync.Mutex
+	label   string
+	byValue map[string]*histogram
+}
+
+func newHistograms(label string) *histograms {
+	return &histograms{label: label, byValue: make(map[string]*histogram)}
+}
+
+// observe records how long something took
+func (h *histograms) observe(value string, elapsed time.Duration) {
+	seconds := elapsed.Seconds()
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	hist := h.byValue[value]
+	if hist == nil {
+		hist = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		h.byValue[value] = hist
+	}
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += seconds
+}
+
+// write writes the histograms in the Prometheus text format
+func (h *histograms) write(w io.Writer, name, help string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v histogram\n", name, help, name)
+	values := []string{}
+	for value := range h.byValue {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		hist := h.byValue[value]
+		label := fmt.Sprintf("%v=%q", h.label, value)
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "%v_bucket{%v,le=\"%v\"} %v\n", name, label, bound, hist.counts[i])
+		}
+		fmt.Fprintf(w, "%v_bucket{%v,le=\"+Inf\"} %v\n", name, label, hist.count)
+		fmt.Fprintf(w, "%v_sum{%v} %v\n", name, label, hist.sum)
+		fmt.Fprintf(w, "%v_count{%v} %v\n", name, label, hist.count)
+	}
+}
+
+// observeChunkLoad records how long a chunk took to load or generate
+func observeChunkLoad(generated bool, elapsed time.Duration) {
+	source := "database"
+	if generated {
+		source = "generated"
+	}
+	chunkLoadTime.observe(source, elapsed)
+}
+
+// observeChunkWrite records how long a chunk took to save
+func observeChunkWrite(elapsed time.Duration) {
+	dbWriteTime.observe("chunk", elapsed)
+}
+
+// observeWrite records how long a database write to a table took since it started
+func observeWrite(table string, start time.Time) {
+	dbWriteTime.observe(table, time.Since(start))
+}
+
+// serveMetrics serves Prometheus metrics at /metrics and a health check at /health, turned on with
+// "metrics=<port>" or "metrics=<host>:<port>" in the server config. A port alone listens on localhost only.
+func (api *API) serveMetrics() {
+	addr := getconfig("metrics")
+	if addr == "" {
+		return
+	}
+	if !strings.Contains(addr, ":") {
+		addr = "127.0.0.1:" + addr
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("Metrics listen error: %v", err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", api.writeMetrics)
+	mux.HandleFunc("/health", api.writeHealth)
+	log.Printf("Metrics listening on %v", addr)
+	go http.Serve(l, mux)
+}
+
+// writeMetrics writes the server's metrics in the Prometheus text format
+func (api *API) writeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintf(w, "# HELP buildorb_players Connected players.\n# TYPE buildorb_players gauge\n")
+	fmt.Fprintf(w, "buildorb_players %v\n", api.sessions.count())
+
+	fmt.Fprintf(w, "# HELP buildorb_chunks_loaded Chunks in memory.\n# TYPE buildorb_chunks_loaded gauge\n")
+	ids := []int{}
+	for id := range universe.PlanetMap {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		planet := universe.PlanetMap[id]
+		planet.ChunksMutex.Lock()
+		n := len(planet.Chunks)
+		planet.ChunksMutex.Unlock()
+		fmt.Fprintf(w, "buildorb_chunks_loaded{planet=\"%v\"} %v\n", id, n)
+	}
+
+	depth, maxDepth := 0, 0
+	for _, s := range api.sessions.all() {
+		n := len(s.out.calls)
+		depth += n
+		if n > maxDepth {
+			maxDepth = n
+		}
+	}
+	fmt.Fprintf(w, "# HELP buildorb_outbound_queue_depth Calls waiting to be sent to clients.\n# TYPE buildorb_outbound_queue_depth gauge\n")
+	fmt.Fprintf(w, "buildorb_outbound_queue_depth %v\n", depth)
+	fmt.Fprintf(w, "# HELP buildorb_outbound_queue_depth_max Calls waiting to be sent to the most backed up client.\n# TYPE buildorb_outbound_queue_depth_max gauge\n")
+	fmt.Fprintf(w, "buildorb_outbound_queue_depth_max %v\n", maxDepth)
+
+	rpcLatency.write(w, "buildorb_rpc_seconds", "Time to handle client calls.")
+	outboundLatency.write(w, "buildorb_outbound_rpc_seconds", "Time for clients to answer server calls.")
+	chunkLoadTime.write(w, "buildorb_chunk_load_seconds", "Time to read a chunk from the database or generate a new one.")
+	dbWriteTime.write(w, "buildorb_db_write_seconds", "Time to write to the database.")
+}
+
+// writeHealth answers 200 while the simulation loop is ticking and the server is not shutting down
+func (api *API) writeHealth(w http.ResponseWriter, r *http.Request) {
+	metrics.mutex.Lock()
+	age := time.Since(metrics.LastTick)
+	metrics.mutex.Unlock()
+	switch {
+	case isStopping():
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "stopping")
+	case age > healthTickAge:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "no tick for %v\n", age.Round(time.Millisecond))
+	default:
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// apiMethods are the methods clients can call on the server, the only ones given latency labels
+var apiMethods = serviceMethods("API", &API{})
+
+// serviceMethods returns the methods net/rpc serves for a receiver registered under a name
+func serviceMethods(name string, receiver interface{}) map[string]bool {
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
+	methods := make(map[string]bool)
+	t := reflect.TypeOf(receiver)
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		mt := m.Type
+		if m.PkgPath != "" || mt.NumIn() != 3 || mt.In(2).Kind() != reflect.Ptr || mt.NumOut() != 1 || mt.Out(0) != errorType {
+			continue
+		}
+		methods[name+"."+m.Name] = true
+	}
+	return methods
+}
+
+// timedCodec is the gob codec net/rpc serves with by default, also timing each call for the metrics endpoint.
+// Only calls to the given methods are timed, so clients cannot add labels by calling made-up methods.
+type timedCodec struct {
+	methods map[string]bool
+	rwc     io.ReadWriteCloser
+	dec     *gob.Decoder
+	enc     *gob.Encoder
+	encBuf  *bufio.Writer
+	mutex   sync.Mutex
+	started map[uint64]timedCall
+}
+
+// timedCall is a call being handled and when it arrived
+type timedCall struct {
+	method string
+	start  time.Time
+}
+
+func newTimedCodec(conn io.ReadWriteCloser, methods map[string]bool) *timedCodec {
+	buf := bufio.NewWriter(conn)
+	return &timedCodec{
+		methods: methods,
+		rwc:     conn,
+		dec:     gob.NewDecoder(conn),
+		enc:     gob.NewEncoder(buf),
+		encBuf:  buf,
+		started: make(map[uint64]timedCall),
+	}
+}
+
+func (c *timedCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := c.dec.Decode(r); err != nil {
+		return err
+	}
+	if c.methods[r.ServiceMethod] {
+		c.mutex.Lock()
+		c.started[r.Seq] = timedCall{method: r.ServiceMethod, start: time.Now()}
+		c.mutex.Unlock()
+	}
+	return nil
+}
+
+func (c *timedCodec) ReadRequestBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *timedCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.mutex.Lock()
+	call, ok := c.started[r.Seq]
+	delete(c.started, r.Seq)
+	c.mutex.Unlock()
+	if ok {
+		rpcLatency.observe(call.method, time.Since(call.start))
+	}
+	if err := c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			c.Close()
+		}
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			c.Close()
+		}
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *timedCodec) Close() error {
+	return c.rwc.Close()
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"net/rpc"
+	"strings"
+	"testing"
+)
+
+type echoService int
+
+func (e *echoService) Echo(text *string, reply *string) error {
+	*reply = *text
+	return nil
+}
+
+func TestTimedCodecCountsCallsPerMethod(t *testing.T) {
+	rpcLatency = newHistograms("method")
+	serverEnd, clientEnd := net.Pipe()
+	s := rpc.NewServer()
+	s.RegisterName("Metrics", new(echoService))
+	go s.ServeCodec(newTimedCodec(serverEnd, serviceMethods("Metrics", new(echoService))))
+	client := rpc.NewClient(clientEnd)
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		var reply string
+		if err := client.Call("Metrics.Echo", "hello", &reply); err != nil || reply != "hello" {
+			t.Fatalf("expected an echo, got %q, %v", reply, err)
+		}
+	}
+	var reply string
+	if err := client.Call("Metrics.Missing", "hello", &reply); err == nil {
+		t.Fatal("expected an error calling a missing method")
+	}
+
+	var buf bytes.Buffer
+	rpcLatency.write(&buf, "test_rpc_seconds", "Test.")
+	out := buf.String()
+	if !strings.Contains(out, `test_rpc_seconds_count{method="Metrics.Echo"} 3`) {
+		t.Fatalf("expected three calls counted, got\n%v", out)
+	}
+	if strings.Contains(out, "Metrics.Missing") {
+		t.Fatalf("a missing method was counted\n%v", out)
+	}
+}
+
+func TestAPIMethodsAreLabelled(t *testing.T) {
+	for _, method := range []string{"API.GetUniverseTime", "API.SetCellMaterial", "API.BreakCell"} {
+		if !apiMethods[method] {
+			t.Fatalf("expected %v to be labelled", method)
+		}
+	}
+	if apiMethods["API.forSession"] || apiMethods["API.Missing"] {
+		t.Fatal("a method clients cannot call is labelled")
+	}
+}
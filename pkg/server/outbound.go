@@ -0,0 +1,136 @@
+package server
+
+import (
+	"log"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// outboundQueueSize is how many calls can wait to be sent to a client before it is disconnected
+const outboundQueueSize = 256
+
+// outboundCall is a call waiting to be sent to a client
+type outboundCall struct {
+	method string
+	args   interface{}
+}
+
+// outbound queues calls to one client and sends them from its own goroutine,
+// so a slow client never holds up anyone else
+type outbound struct {
+	rpc    *rpc.Client
+	calls  chan outboundCall
+	closed chan bool
+	once   sync.Once
+
+	// Only the newest state of each person is sent, so position updates
+	// that are superseded before they go out are dropped
+	statesMutex sync.Mutex
+	states      map[string]*common.PlayerState
+	statesReady chan bool
+}
+
+func newOutbound(client *rpc.Client) *outbound {
+	return &outbound{
+		rpc:         client,
+		calls:       make(chan outboundCall, outboundQueueSize),
+		closed:      make(chan bool),
+		states:      make(map[string]*common.PlayerState),
+		statesReady: make(chan bool, 1),
+	}
+}
+
+// send queues a call, closing the queue if it is full. Any state of a person not yet sent
+// is dropped when they are disconnected, so it cannot reach the client after they are forgotten.
+func (o *outbound) send(method string, args interface{}) {
+	if name, ok := args.(string); ok && method == "API.PersonDisconnected" {
+		o.statesMutex.Lock()
+		delete(o.states, name)
+		o.statesMutex.Unlock()
+	}
+	select {
+	case <-o.closed:
+	case o.calls <- outboundCall{method: method, args: args}:
+	default:
+		log.Printf("Outbound queue full, dropping client")
+		o.close()
+	}
+}
+
+// sendState queues a person's state, replacing any of their states not yet sent
+func (o *outbound) sendState(state *common.PlayerState) {
+	o.statesMutex.Lock()
+	o.states[state.Name] = state
+	o.statesMutex.Unlock()
+	select {
+	case o.statesReady <- true:
+	default:
+	}
+}
+
+// sendAndClose queues a last call, closing the queue once it has been sent
+func (o *outbound) sendAndClose(method string, args interface{}) {
+	o.send(method, args)
+	o.send("", nil)
+}
+
+// close stops sending and shuts down the connection to the client
+func (o *outbound) close() {
+	o.once.Do(func() {
+		close(o.closed)
+		o.rpc.Close()
+	})
+}
+
+// run sends queued calls until the queue is closed or the connection shuts down
+func (o *outbound) run() {
+	for {
+		select {
+		case <-o.closed:
+			return
+		case c := <-o.calls:
+			if c.method == "" {
+				return
+			}
+			o.call(c.method, c.args)
+		case <-o.statesReady:
+			o.statesMutex.Lock()
+			states := o.states
+			o.states = make(map[string]*common.PlayerState)
+			o.statesMutex.Unlock()
+			for _, state := range states {
+				o.call("API.UpdatePersonState", state)
+			}
+		}
+	}
+}
+
+func (o *outbound) call(method string, args interface{}) {
+	var ret bool
+	start := time.Now()
+	err := o.rpc.Call(method, args, &ret)
+	outboundLatency.observe(method, time.Since(start))
+	if _, ok := err.(rpc.ServerError); ok {
+		log.Printf("%v error: %v", method, err)
+	} else if err != nil {
+		o.close()
+	}
+}
+
+// broadcast queues a call to everyone connected
+func (api *API) broadcast(method string, args interface{}) {
+	for _, s := range api.sessions.all() {
+		s.out.send(method, args)
+	}
+}
+
+// sendTo queues a call to one person
+func (api *API) sendTo(name, method string, args interface{}) {
+	s := api.sessions.byName(name)
+	if s != nil {
+		s.out.send(method, args)
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net"
+	"net/rpc"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+func TestOutboundSendsOnlyNewestState(t *testing.T) {
+	c, client := newTestClient(t)
+	o := newOutbound(client)
+	defer o.close()
+	for i := 0; i < 5; i++ {
+		o.sendState(&common.PlayerState{Name: "bob", Position: [3]float32{float32(i), 0, 0}})
+	}
+	go o.run()
+	waitFor(t, "the state to be sent", func() bool {
+		return atomic.LoadInt64(&c.states) > 0
+	})
+	time.Sleep(50 * time.Millisecond)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if atomic.LoadInt64(&c.states) != 1 || c.lastState.Position[0] != 4 {
+		t.Fatalf("expected only the newest state, got %v states ending at %v", c.states, c.lastState.Position)
+	}
+}
+
+func TestOutboundClosesWhenQueueIsFull(t *testing.T) {
+	// A client that never reads from its connection
+	serverEnd, clientEnd := net.Pipe()
+	defer clientEnd.Close()
+	o := newOutbound(rpc.NewClient(serverEnd))
+	for i := 0; i <= outboundQueueSize; i++ {
+		o.send("API.Chat", &common.ChatMessage{Text: "hello"})
+	}
+	select {
+	case <-o.closed:
+	default:
+		t.Fatal("expected the queue to close once full")
+	}
+}
+
+func TestOutboundDisconnectDropsUnsentState(t *testing.T) {
+	c, client := newTestClient(t)
+	o := newOutbound(client)
+	defer o.close()
+	o.sendState(&common.PlayerState{Name: "bob"})
+	o.send("API.PersonDisconnected", "bob")
+	go o.run()
+	waitFor(t, "bob to be forgotten", func() bool {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		return len(c.people) > 0
+	})
+	time.Sleep(50 * time.Millisecond)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.people) != 1 || c.people[0] != "gone bob" {
+		t.Fatalf("expected bob to be forgotten with no state after, got %v", c.people)
+	}
+}
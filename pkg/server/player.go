@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"log"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// playerRecord is what the server saves of a player between visits
+type playerRecord struct {
+	State    common.PlayerState
+	Items    map[int]int
+	GameMode int
+}
+
+// savePlayer saves a player's state, items and game mode
+func savePlayer(s *session) {
+	s.mutex.Lock()
+	record := playerRecord{State: s.state, Items: make(map[int]int), GameMode: s.gameMode}
+	for material, amount := range s.items {
+		record.Items[material] = amount
+	}
+	s.mutex.Unlock()
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	checkErr(enc.Encode(record))
+	defer observeWrite("player", time.Now())
+	_, err := db.Exec("INSERT OR REPLACE INTO player VALUES (?, ?)", s.name, buf.Bytes())
+	checkErr(err)
+}
+
+// loadPlayer gives a joining player the items and game mode they had when they last left.
+// Players new to the world keep the server's default game mode and start with nothing.
+func loadPlayer(s *session) {
+	var data []byte
+	err := db.QueryRow("SELECT data FROM player WHERE name = ?", s.name).Scan(&data)
+	if err == sql.ErrNoRows {
+		return
+	}
+	checkErr(err)
+	var record playerRecord
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&record); err != nil {
+		log.Printf("Could not load %v, starting them afresh: %v", s.name, err)
+		return
+	}
+	s.mutex.Lock()
+	if record.Items != nil {
+		s.items = record.Items
+	}
+	s.gameMode = record.GameMode
+	s.mutex.Unlock()
+}
+
+// sendPlayer sends a joining client the game mode and items the server has for them
+func (s *session) sendPlayer() {
+	s.mutex.Lock()
+	mode := s.gameMode
+	s.mutex.Unlock()
+	s.out.send("API.SetGameMode", &mode)
+	s.sendInventory()
+}
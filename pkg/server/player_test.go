@@ -0,0 +1,88 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+func TestGameModeIsSetByServer(t *testing.T) {
+	useTestWorld(t, "")
+	planet := common.NewPlanet(common.PlanetState{ID: 0, Radius: 32, AltCells: 32}, nil, nil)
+	universe = &common.Universe{PlanetMap: map[int]*common.Planet{0: planet}}
+	ind := common.PlanetCellIndex{CellIndex: common.CellIndex{Lon: 1, Lat: planet.LatCells / 2, Alt: 20}}
+	planet.CellIndexToCell(ind.CellIndex).Material = common.Air
+	pos := planet.CellIndexToCartesian(ind.CellIndex)
+
+	api := newAPI()
+	c, client := newTestClient(t)
+	s, err := api.join(client, common.PlayerState{Name: "alice", Planet: 0, Position: pos}, common.Capabilities, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.creative() {
+		t.Fatal("expected players to join in survival mode by default")
+	}
+	place := func() error {
+		var ret bool
+		return api.forSession(s).SetCellMaterial(&common.RPCSetCellMaterialArgs{Planet: 0, Index: ind.CellIndex, Material: common.Stone}, &ret)
+	}
+	if err := place(); err == nil || err.Error() != "You have none of that material" {
+		t.Fatalf("expected a survival player without stone to be refused, got %v", err)
+	}
+	waitFor(t, "the inventory to be sent again after the refusal", func() bool {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		return c.inventories == 1 && len(c.inventory) == 0
+	})
+
+	s.setGameMode(common.Creative)
+	if err := place(); err != nil {
+		t.Fatalf("expected a creative player to place without items, got %v", err)
+	}
+
+	useTestWorld(t, "gamemode=creative;")
+	_, client = newTestClient(t)
+	s, err = api.join(client, common.PlayerState{Name: "bob"}, common.Capabilities, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.creative() {
+		t.Fatal("expected players to join in creative mode when the server config says so")
+	}
+}
+
+func TestItemsAndGameModeAreSavedWithPlayer(t *testing.T) {
+	useTestWorld(t, "")
+	api := newAPI()
+	c, client := newTestClient(t)
+	s, err := api.join(client, common.PlayerState{Name: "alice"}, common.Capabilities, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.addItems(common.Slot{Material: common.Stone, Amount: 5})
+	waitFor(t, "the client to get its inventory", func() bool {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		return len(c.inventory) == 1 && c.inventory[0] == common.Slot{Material: common.Stone, Amount: 5}
+	})
+	s.setGameMode(common.Creative)
+	savePlayer(s)
+	api.sessions.remove(s.id)
+
+	c, client = newTestClient(t)
+	s, err = api.join(client, common.PlayerState{Name: "alice"}, common.Capabilities, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadPlayer(s)
+	s.sendPlayer()
+	waitFor(t, "the client to get its game mode and inventory", func() bool {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		return c.gameMode == common.Creative && len(c.inventory) == 1
+	})
+	if !s.takeItems(common.Slot{Material: common.Stone, Amount: 5}) {
+		t.Fatal("expected the player's items to be loaded")
+	}
+}
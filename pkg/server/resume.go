@@ -0,0 +1,73 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// resumeWindow is how long the server keeps the place of a player whose connection dropped
+const resumeWindow = 5 * time.Minute
+
+// leftSession is what a player who left had on the server, kept so they can resume after reconnecting
+type leftSession struct {
+	state    common.PlayerState
+	items    map[int]int
+	gameMode int
+	left     time.Time
+}
+
+var (
+	resumeMutex  sync.Mutex
+	leftSessions = make(map[string]*leftSession)
+)
+
+// keepForResume remembers a session that left, forgetting any that left too long ago
+func keepForResume(s *session) {
+	s.mutex.Lock()
+	left := &leftSession{state: s.state, items: make(map[int]int), gameMode: s.gameMode, left: time.Now()}
+	for material, amount := range s.items {
+		left.items[material] = amount
+	}
+	s.mutex.Unlock()
+	resumeMutex.Lock()
+	defer resumeMutex.Unlock()
+	for name, l := range leftSessions {
+		if time.Since(l.left) > resumeWindow {
+			delete(leftSessions, name)
+		}
+	}
+	leftSessions[s.name] = left
+}
+
+// takeLeftSession returns and forgets what a player had when they left, or nil if they left too long ago
+func takeLeftSession(name string) *leftSession {
+	resumeMutex.Lock()
+	defer resumeMutex.Unlock()
+	left := leftSessions[name]
+	delete(leftSessions, name)
+	if left == nil || time.Since(left.left) > resumeWindow {
+		return nil
+	}
+	return left
+}
+
+// restore gives a session back what the player had when they left
+func (s *session) restore(left *leftSession) {
+	s.mutex.Lock()
+	s.state = left.state
+	s.state.Name = s.name
+	s.items = left.items
+	s.gameMode = left.gameMode
+	s.mutex.Unlock()
+}
+
+// takeOver ends any session a resuming player still has, since the server may not have noticed their
+// connection dropped, and returns what they had so their new session can carry on from it
+func (api *API) takeOver(name string) *leftSession {
+	if old := api.sessions.byName(name); old != nil {
+		api.sessions.remove(old.id)
+	}
+	return takeLeftSession(name)
+}
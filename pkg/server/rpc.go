@@ -7,9 +7,31 @@ import (
 	"github.com/jeffbaumes/buildorb/pkg/common"
 )
 
-// API is the RPC tag for server calls
+// API is the RPC tag for server calls. Each connection is served by its own API
+// bound to its session, so calls act as the player who authenticated.
 type API struct {
-	connectedPeople []*connectedPerson
+	sessions *sessionRegistry
+	session  *session
+}
+
+// newAPI creates the server API with no one connected
+func newAPI() *API {
+	api := &API{sessions: newSessionRegistry()}
+	api.sessions.onEvent(api.sessionEvent)
+	return api
+}
+
+// forSession returns an API for calls from a session
+func (api *API) forSession(s *session) *API {
+	return &API{sessions: api.sessions, session: s}
+}
+
+// caller returns the name of the player making calls, ignoring any name the client claims
+func (api *API) caller() string {
+	if api.session == nil {
+		return ""
+	}
+	return api.session.Name()
 }
 
 // GetPlanetStates returns all planets
@@ -22,7 +44,13 @@ func (api *API) GetPlanetStates(args *int, states *[]*common.PlanetState) error
 	return nil
 }
 
-// GetChunk returns the planet chunk for the given chunk coordinates
+// GetUniverseTime returns the universe clock in seconds
+func (api *API) GetUniverseTime(args *int, seconds *float64) error {
+	*seconds = universe.Time()
+	return nil
+}
+
+// GetChunk returns the planet chunk for the given chunk coordinates, without subscribing to its changes
 func (api *API) GetChunk(args *common.PlanetChunkIndex, chunk *common.Chunk) error {
 	planet := universe.PlanetMap[args.Planet]
 	if planet == nil {
@@ -48,100 +76,253 @@ func (api *API) GetPlanetGeometry(planetID *int, geom *common.PlanetGeometry) er
 	return nil
 }
 
-// UpdatePersonState updates a person's position
+// UpdatePersonState updates the caller's position
 func (api *API) UpdatePersonState(state *common.PlayerState, ret *bool) error {
-	var validPeople []*connectedPerson
-	for _, c := range api.connectedPeople {
-		if c.state.Name == state.Name {
-			c.state = *state
-		} else {
-			var r bool
-			e := c.rpc.Call("API.UpdatePersonState", state, &r)
-			if e != nil {
-				if e.Error() == "connection is shut down" {
-					api.personDisconnected(c.state.Name)
-					continue
-				}
-				log.Println("UpdatePersonState error:", e)
-			}
-		}
-		validPeople = append(validPeople, c)
+	if api.session == nil {
+		return errors.New("Not joined")
 	}
-	api.connectedPeople = validPeople
+	api.session.setState(*state)
+	state.Name = api.session.Name()
+	api.sendState(api.session, state)
 	*ret = true
 	return nil
 }
 
-// SendText sends a text to all players
-func (api *API) SendText(text *string, ret *bool) error {
-	var validPeople []*connectedPerson
-	for _, c := range api.connectedPeople {
-		var r bool
-		e := c.rpc.Call("API.SendText", text, &r)
-		if e != nil {
-			if e.Error() == "connection is shut down" {
-				api.personDisconnected(c.state.Name)
-				continue
-			}
-			log.Println("UpdatePersonState error:", e)
-		}
-		validPeople = append(validPeople, c)
+// HitPlayer damages a person within reach of the caller
+func (api *API) HitPlayer(args *common.HitPlayerArgs, ret *bool) error {
+	if api.session == nil {
+		return errors.New("Not joined")
+	}
+	if !api.session.allowEdit() {
+		return errors.New("Too many hits, slow down")
+	}
+	target := api.sessions.byName(args.Target)
+	if target == nil || target == api.session {
+		return errors.New("No player to hit")
 	}
-	api.connectedPeople = validPeople
+	state := target.State()
+	if !api.inReach(state.Planet, state.Position) {
+		return errors.New("Player is out of reach")
+	}
+	args.From = api.caller()
+	args.Amount = meleeDamage
+	api.hitPlayer(args)
 	*ret = true
 	return nil
 }
 
-// HitPlayer damages a person
-func (api *API) HitPlayer(args *common.HitPlayerArgs, ret *bool) error {
-	var validPeople []*connectedPerson
-	for _, c := range api.connectedPeople {
-		if c.state.Name == args.Target {
-			var r bool
-			e := c.rpc.Call("API.HitPlayer", args, &r)
-			if e != nil {
-				if e.Error() == "connection is shut down" {
-					api.personDisconnected(c.state.Name)
-					continue
-				}
-				log.Println("HitPlayer error:", e)
-			}
+// hitPlayer sends damage to the targeted person
+func (api *API) hitPlayer(args *common.HitPlayerArgs) {
+	api.sendTo(args.Target, "API.HitPlayer", args)
+}
+
+// SetCellMaterial places a material in an empty cell within reach of the caller,
+// using up one of that material they collected unless they are in creative mode
+func (api *API) SetCellMaterial(args *common.RPCSetCellMaterialArgs, ret *bool) error {
+	editMutex.Lock()
+	err := api.placeCell(args, ret)
+	editMutex.Unlock()
+	// The client used up the item when it placed the cell, so tell it what it still has
+	if err != nil && api.session != nil && !api.session.creative() {
+		api.session.sendInventory()
+	}
+	return err
+}
+
+func (api *API) placeCell(args *common.RPCSetCellMaterialArgs, ret *bool) error {
+	_, cell, err := api.checkEdit(common.PlanetCellIndex{Planet: args.Planet, CellIndex: args.Index})
+	if err != nil {
+		return err
+	}
+	if err := checkMaterial(args.Material); err != nil {
+		return err
+	}
+	if args.Material == common.Air {
+		return errors.New("Cells must be mined to be removed")
+	}
+	if cell.Material != common.Air {
+		return errors.New("Cell is not empty")
+	}
+	creative := api.session.creative()
+	if !creative && !api.session.takeItems(common.Slot{Material: args.Material, Amount: 1}) {
+		return errors.New("You have none of that material")
+	}
+	changed, contents, err := api.setCellMaterial(args)
+	if err != nil {
+		if !creative {
+			api.session.addItems(common.Slot{Material: args.Material, Amount: 1})
 		}
-		validPeople = append(validPeople, c)
+		return err
 	}
-	api.connectedPeople = validPeople
-	*ret = true
+	api.session.addItems(contents...)
+	*ret = changed
 	return nil
 }
 
-// SetCellMaterial sets the material for a particular cell
-func (api *API) SetCellMaterial(args *common.RPCSetCellMaterialArgs, ret *bool) error {
+// setCellMaterial sets the material for a particular cell and sends the change to clients holding its chunk.
+// A block entity the cell held is removed by the server's rule, returning its contents to drop, or the cell
+// is left as it is with an error if the contents are kept.
+func (api *API) setCellMaterial(args *common.RPCSetCellMaterialArgs) (bool, []common.Slot, error) {
 	planet := universe.PlanetMap[args.Planet]
-	if planet == nil {
-		return errors.New("Unknown planet ID")
-	}
-	*ret = planet.SetCellMaterial(args.Index, args.Material, false)
-	var validPeople []*connectedPerson
-	for _, c := range api.connectedPeople {
-		var ret bool
-		e := c.rpc.Call("API.SetCellMaterial", args, &ret)
-		if e != nil {
-			if e.Error() == "connection is shut down" {
-				api.personDisconnected(c.state.Name)
-				continue
-			}
-			log.Println("SetCellMaterial error:", e)
+	ind := common.PlanetCellIndex{Planet: args.Planet, CellIndex: args.Index}
+	cell := planet.CellIndexToCell(args.Index)
+	var contents []common.Slot
+	if cell != nil && common.HasBlockEntity(cell.Material) && cell.Material != args.Material {
+		blockEntitiesMutex.Lock()
+		var err error
+		contents, err = api.breakBlockEntity(ind)
+		blockEntitiesMutex.Unlock()
+		if err != nil {
+			return false, nil, err
 		}
-		validPeople = append(validPeople, c)
 	}
-	api.connectedPeople = validPeople
+	changes := []common.CellChange{{Index: args.Index, Material: args.Material, State: args.State}}
+	return len(api.changeCells(planet, changes)) > 0, contents, nil
+}
+
+// OpenBlockEntity returns the block entity at a cell and sends the caller any later changes to it
+func (api *API) OpenBlockEntity(args *common.BlockEntityArgs, entity *common.BlockEntity) error {
+	editMutex.Lock()
+	defer editMutex.Unlock()
+	if _, _, err := api.checkEdit(args.PlanetCellIndex); err != nil {
+		return err
+	}
+	blockEntitiesMutex.Lock()
+	defer blockEntitiesMutex.Unlock()
+	e := getBlockEntity(args.PlanetCellIndex)
+	if e == nil {
+		return errors.New("No block entity at cell")
+	}
+	e.viewers[api.caller()] = true
+	*entity = e.BlockEntity
+	entity.Slots = append([]common.Slot{}, e.Slots...)
+	return nil
+}
+
+// CloseBlockEntity stops sending changes of a block entity to the caller
+func (api *API) CloseBlockEntity(args *common.BlockEntityArgs, ret *bool) error {
+	blockEntitiesMutex.Lock()
+	defer blockEntitiesMutex.Unlock()
+	e := blockEntities[args.PlanetCellIndex]
+	if e != nil {
+		delete(e.viewers, api.caller())
+	}
+	*ret = true
+	return nil
+}
+
+// SetBlockEntitySlot swaps the contents of one slot of a block entity with items
+// from the caller's inventory and updates everyone viewing it
+func (api *API) SetBlockEntitySlot(args *common.BlockEntitySlotArgs, ret *bool) error {
+	editMutex.Lock()
+	defer editMutex.Unlock()
+	if _, _, err := api.checkEdit(args.PlanetCellIndex); err != nil {
+		return err
+	}
+	if err := checkMaterial(args.Contents.Material); err != nil {
+		return err
+	}
+	if args.Contents.Amount < 0 {
+		return errors.New("Invalid item amount")
+	}
+	blockEntitiesMutex.Lock()
+	defer blockEntitiesMutex.Unlock()
+	e := getBlockEntity(args.PlanetCellIndex)
+	if e == nil {
+		return errors.New("No block entity at cell")
+	}
+	if !e.viewers[api.caller()] {
+		return errors.New("Block entity is not open")
+	}
+	if args.Slot < 0 || args.Slot >= len(e.Slots) {
+		return errors.New("Invalid block entity slot")
+	}
+	if !api.session.creative() && !api.session.takeItems(args.Contents) {
+		return errors.New("You do not have those items")
+	}
+	api.session.addItems(e.Slots[args.Slot])
+	e.Slots[args.Slot] = args.Contents
+	saveBlockEntity(e)
+	api.updateBlockEntityViewers(e)
+	*ret = true
+	return nil
+}
+
+// TriggerCell triggers a cell, lighting the fuse of an explosive
+func (api *API) TriggerCell(args *common.TriggerArgs, ret *bool) error {
+	editMutex.Lock()
+	defer editMutex.Unlock()
+	_, cell, err := api.checkEdit(args.PlanetCellIndex)
+	if err != nil {
+		return err
+	}
+	if !common.IsExplosive(cell.Material) {
+		return errors.New("Cell cannot be triggered")
+	}
+	from := api.caller()
+	scheduleBlockUpdate(explosiveFuse, func(api *API) {
+		api.explode(from, args.PlanetCellIndex)
+	})
+	*ret = true
+	return nil
+}
+
+// StartMining records that the caller started mining a cell, so a later BreakCell can be validated
+func (api *API) StartMining(args *common.BreakCellArgs, ret *bool) error {
+	if _, _, err := api.checkEdit(args.PlanetCellIndex); err != nil {
+		return err
+	}
+	startMining(api.caller(), args.PlanetCellIndex)
+	*ret = true
+	return nil
+}
+
+// BreakCell breaks a cell the caller has mined for long enough, or right away in creative mode, returning
+// any block entity contents dropped to the caller. Survival players also collect the cell's material.
+func (api *API) BreakCell(args *common.BreakCellArgs, contents *[]common.Slot) error {
+	editMutex.Lock()
+	defer editMutex.Unlock()
+	_, cell, err := api.checkEdit(args.PlanetCellIndex)
+	if err != nil {
+		return err
+	}
+	material := cell.Material
+	if material == common.Air {
+		return errors.New("No cell to break")
+	}
+	args.From = api.caller()
+	creative := api.session.creative()
+	if creative {
+		stopMining(args.From)
+	} else if err := checkMining(args, material); err != nil {
+		return err
+	}
+	_, dropped, err := api.setCellMaterial(&common.RPCSetCellMaterialArgs{
+		Planet:   args.Planet,
+		Index:    args.CellIndex,
+		Material: common.Air,
+	})
+	if err != nil {
+		return err
+	}
+	*contents = dropped
+	if !creative {
+		api.session.addItems(common.Slot{Material: material, Amount: 1})
+	}
+	api.session.addItems(*contents...)
 	return nil
 }
 
 func (api *API) personDisconnected(name string) {
 	log.Printf("%v disconnected", name)
-	for _, c := range api.connectedPeople {
-		var ret bool
-		c.rpc.Call("API.PersonDisconnected", name, &ret)
+	stopMining(name)
+	blockEntitiesMutex.Lock()
+	for _, e := range blockEntities {
+		delete(e.viewers, name)
+	}
+	blockEntitiesMutex.Unlock()
+	for _, s := range api.sessions.all() {
+		s.setVisible(name, false)
 	}
+	api.broadcast("API.PersonDisconnected", name)
 }
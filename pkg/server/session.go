@@ -0,0 +1,215 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// Kinds of session events
+const (
+	sessionJoined = iota
+	sessionLeft
+)
+
+// sessionEvent tells listeners that a session joined or left
+type sessionEvent struct {
+	kind    int
+	session *session
+}
+
+// session is one connected client
+type session struct {
+	id           uint64
+	name         string
+	out          *outbound
+	conn         io.Closer
+	capabilities map[string]bool
+	mutex        sync.Mutex
+	state        common.PlayerState
+	lastSeen     time.Time
+
+	// What the player has collected and may place, and their rate limit on edits
+	items      map[int]int
+	editTokens float64
+	editTime   time.Time
+
+	// Whether the player is in creative or survival mode
+	gameMode int
+
+	// The chunks the client is subscribed to and the people it is being sent
+	chunks  map[common.PlanetChunkIndex]bool
+	visible map[string]bool
+}
+
+// Name returns the name the player joined as
+func (s *session) Name() string {
+	return s.name
+}
+
+// State returns the last state the client sent
+func (s *session) State() common.PlayerState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.state
+}
+
+// setState records a new state from the client, keeping the name it joined as
+func (s *session) setState(state common.PlayerState) {
+	state.Name = s.name
+	s.mutex.Lock()
+	s.state = state
+	s.lastSeen = time.Now()
+	s.mutex.Unlock()
+}
+
+// HasCapability returns whether the client and server both support an optional feature
+func (s *session) HasCapability(c string) bool {
+	return s.capabilities[c]
+}
+
+// LastSeen returns when the client last sent its state
+func (s *session) LastSeen() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastSeen
+}
+
+// sessionRegistry holds the connected sessions, safe for use from any goroutine
+type sessionRegistry struct {
+	mutex     sync.RWMutex
+	nextID    uint64
+	sessions  map[uint64]*session
+	listeners []func(sessionEvent)
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{sessions: make(map[uint64]*session)}
+}
+
+// onEvent adds a function called after every join and leave.
+// Listeners are called without the registry locked, so they may use it.
+func (r *sessionRegistry) onEvent(f func(sessionEvent)) {
+	r.mutex.Lock()
+	r.listeners = append(r.listeners, f)
+	r.mutex.Unlock()
+}
+
+func (r *sessionRegistry) notify(e sessionEvent) {
+	r.mutex.RLock()
+	listeners := append([]func(sessionEvent){}, r.listeners...)
+	r.mutex.RUnlock()
+	for _, f := range listeners {
+		f(e)
+	}
+}
+
+// add registers a new session with a unique ID, failing if a session with the same name exists.
+// The connection, if any, is closed when the session is removed.
+func (r *sessionRegistry) add(out *outbound, conn io.Closer, state common.PlayerState, capabilities []string) (*session, error) {
+	s := &session{name: state.Name, out: out, conn: conn, state: state, capabilities: make(map[string]bool), lastSeen: time.Now(), items: make(map[int]int), gameMode: defaultGameMode(),
+		chunks: make(map[common.PlanetChunkIndex]bool), visible: make(map[string]bool)}
+	for _, c := range capabilities {
+		s.capabilities[c] = true
+	}
+	r.mutex.Lock()
+	for _, other := range r.sessions {
+		if other.name == s.name {
+			r.mutex.Unlock()
+			return nil, errors.New(s.name + " is already playing on this server")
+		}
+	}
+	r.nextID++
+	s.id = r.nextID
+	r.sessions[s.id] = s
+	r.mutex.Unlock()
+	r.notify(sessionEvent{kind: sessionJoined, session: s})
+	return s, nil
+}
+
+// remove unregisters a session and closes its connection.
+// It returns false if the session was already removed.
+func (r *sessionRegistry) remove(id uint64) bool {
+	r.mutex.Lock()
+	s := r.sessions[id]
+	delete(r.sessions, id)
+	r.mutex.Unlock()
+	if s == nil {
+		return false
+	}
+	s.out.close()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	r.notify(sessionEvent{kind: sessionLeft, session: s})
+	return true
+}
+
+// get returns the session with an ID, or nil
+func (r *sessionRegistry) get(id uint64) *session {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.sessions[id]
+}
+
+// byName returns the session of a named player, or nil
+func (r *sessionRegistry) byName(name string) *session {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, s := range r.sessions {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// all returns a snapshot of the connected sessions
+func (r *sessionRegistry) all() []*session {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	sessions := make([]*session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// count returns the number of connected sessions
+func (r *sessionRegistry) count() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.sessions)
+}
+
+// join registers a connected client and starts sending to it.
+// The session is removed once its outbound queue closes.
+func (api *API) join(client *rpc.Client, state common.PlayerState, capabilities []string, conn io.Closer) (*session, error) {
+	s, err := api.sessions.add(newOutbound(client), conn, state, capabilities)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		s.out.run()
+		api.sessions.remove(s.id)
+	}()
+	return s, nil
+}
+
+// sessionEvent handles sessions joining and leaving
+func (api *API) sessionEvent(e sessionEvent) {
+	name := e.session.Name()
+	switch e.kind {
+	case sessionJoined:
+		log.Printf("%v joined as session %v", name, e.session.id)
+		sendChatHistory(e.session)
+	case sessionLeft:
+		keepForResume(e.session)
+		api.personDisconnected(name)
+	}
+}
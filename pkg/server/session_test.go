@@ -0,0 +1,618 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// testClient is the client side of a simulated connection
+type testClient struct {
+	states  int64
+	conn    net.Conn
+	mutex   sync.Mutex
+	deltas  []common.ChunkDelta
+	reason  string
+	chat    []common.ChatMessage
+	history []common.ChatMessage
+
+	people      []string
+	lastState   common.PlayerState
+	inventories int
+	inventory   []common.Slot
+	gameMode    int
+}
+
+func (c *testClient) Chat(msg *common.ChatMessage, ret *bool) error {
+	c.mutex.Lock()
+	c.chat = append(c.chat, *msg)
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *testClient) ChatHistory(msgs *[]common.ChatMessage, ret *bool) error {
+	c.mutex.Lock()
+	c.history = append(c.history, *msgs...)
+	c.mutex.Unlock()
+	return nil
+}
+
+// chatTexts returns the texts of the chat messages the client received
+func (c *testClient) chatTexts() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	texts := []string{}
+	for _, msg := range c.chat {
+		texts = append(texts, msg.Text)
+	}
+	return texts
+}
+
+func (c *testClient) UpdatePersonState(state *common.PlayerState, ret *bool) error {
+	atomic.AddInt64(&c.states, 1)
+	c.mutex.Lock()
+	c.people = append(c.people, "state "+state.Name)
+	c.lastState = *state
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *testClient) PersonDisconnected(name *string, ret *bool) error {
+	c.mutex.Lock()
+	c.people = append(c.people, "gone "+*name)
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *testClient) Disconnect(reason *string, ret *bool) error {
+	c.mutex.Lock()
+	c.reason = *reason
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *testClient) SetInventory(items *[]common.Slot, ret *bool) error {
+	c.mutex.Lock()
+	c.inventories++
+	c.inventory = *items
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *testClient) SetGameMode(mode *int, ret *bool) error {
+	c.mutex.Lock()
+	c.gameMode = *mode
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *testClient) ChunkDelta(delta *common.ChunkDelta, ret *bool) error {
+	c.mutex.Lock()
+	c.deltas = append(c.deltas, *delta)
+	c.mutex.Unlock()
+	return nil
+}
+
+// newTestClient connects a simulated client over an in-memory pipe,
+// returning the client and the server's RPC client for calling it
+func newTestClient(t *testing.T) (*testClient, *rpc.Client) {
+	serverEnd, clientEnd := net.Pipe()
+	c := &testClient{conn: clientEnd}
+	s := rpc.NewServer()
+	if err := s.RegisterName("API", c); err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeConn(clientEnd)
+	return c, rpc.NewClient(serverEnd)
+}
+
+// waitFor polls until a condition holds or a timeout passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSessionIDsAreUnique(t *testing.T) {
+	r := newSessionRegistry()
+	const n = 200
+	ids := make(chan uint64, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, client := newTestClient(t)
+			s, err := r.add(newOutbound(client), nil, common.PlayerState{Name: fmt.Sprintf("p%v", i)}, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ids <- s.id
+		}(i)
+	}
+	wg.Wait()
+	close(ids)
+	seen := make(map[uint64]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicate session ID %v", id)
+		}
+		seen[id] = true
+	}
+	if r.count() != n {
+		t.Fatalf("expected %v sessions, got %v", n, r.count())
+	}
+}
+
+func TestSessionEventsFireOncePerJoinAndLeave(t *testing.T) {
+	r := newSessionRegistry()
+	var mutex sync.Mutex
+	joins := make(map[uint64]int)
+	leaves := make(map[uint64]int)
+	r.onEvent(func(e sessionEvent) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if e.kind == sessionJoined {
+			joins[e.session.id]++
+		} else {
+			leaves[e.session.id]++
+		}
+	})
+
+	const n = 100
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, client := newTestClient(t)
+			s, err := r.add(newOutbound(client), nil, common.PlayerState{Name: fmt.Sprintf("p%v", i)}, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			r.all()
+			r.byName(fmt.Sprintf("p%v", (i+1)%n))
+
+			// Remove the same session from several goroutines at once
+			var removers sync.WaitGroup
+			var removed int64
+			for j := 0; j < 3; j++ {
+				removers.Add(1)
+				go func() {
+					defer removers.Done()
+					if r.remove(s.id) {
+						atomic.AddInt64(&removed, 1)
+					}
+				}()
+			}
+			removers.Wait()
+			if removed != 1 {
+				t.Errorf("session %v removed %v times", s.id, removed)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if r.count() != 0 {
+		t.Fatalf("expected no sessions, got %v", r.count())
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(joins) != n || len(leaves) != n {
+		t.Fatalf("expected %v joins and leaves, got %v and %v", n, len(joins), len(leaves))
+	}
+	for id, count := range joins {
+		if count != 1 || leaves[id] != 1 {
+			t.Fatalf("session %v joined %v times and left %v times", id, count, leaves[id])
+		}
+	}
+}
+
+func TestManyClientsConnectAndDisconnect(t *testing.T) {
+	api := newAPI()
+	const n = 50
+	clients := make([]*testClient, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, client := newTestClient(t)
+			clients[i] = c
+			name := fmt.Sprintf("p%v", i)
+			s, err := api.join(client, common.PlayerState{Name: name}, common.Capabilities, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			sapi := api.forSession(s)
+			var ret bool
+			for j := 0; j < 10; j++ {
+				sapi.UpdatePersonState(&common.PlayerState{Name: name, Position: [3]float32{float32(j), 0, 0}}, &ret)
+			}
+			text := "hello from " + name
+			sapi.Chat(&text, &ret)
+		}(i)
+	}
+	wg.Wait()
+
+	// Everyone connected before the last client joined, so each client hears the last text
+	waitFor(t, "texts to arrive", func() bool {
+		for _, c := range clients {
+			if len(c.chatTexts()) == 0 {
+				return false
+			}
+		}
+		return true
+	})
+
+	// Half the clients drop their connections while the rest keep chatting
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				clients[i].conn.Close()
+				return
+			}
+			api.announce(fmt.Sprintf("still here %v", i))
+		}(i)
+	}
+	wg.Wait()
+
+	waitFor(t, "dropped clients to leave", func() bool {
+		api.announce("ping")
+		return api.sessions.count() == n/2
+	})
+	for _, s := range api.sessions.all() {
+		var i int
+		fmt.Sscanf(s.Name(), "p%d", &i)
+		if i%2 == 0 {
+			t.Fatalf("%v dropped its connection but is still registered", s.Name())
+		}
+	}
+
+	for _, s := range api.sessions.all() {
+		wg.Add(1)
+		go func(id uint64) {
+			defer wg.Done()
+			api.sessions.remove(id)
+		}(s.id)
+	}
+	wg.Wait()
+	if api.sessions.count() != 0 {
+		t.Fatalf("expected no sessions, got %v", api.sessions.count())
+	}
+}
+
+func TestFullQueueDisconnectsClient(t *testing.T) {
+	api := newAPI()
+
+	// A client that never reads from its connection
+	serverEnd, clientEnd := net.Pipe()
+	defer clientEnd.Close()
+	if _, err := api.join(rpc.NewClient(serverEnd), common.PlayerState{Name: "stalled"}, common.Capabilities, serverEnd); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < outboundQueueSize*2; i++ {
+		api.announce("hello")
+	}
+	waitFor(t, "stalled client to be dropped", func() bool {
+		return api.sessions.count() == 0
+	})
+}
+
+func TestDuplicateNameJoinsOnce(t *testing.T) {
+	api := newAPI()
+	const n = 20
+	var joined int64
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, client := newTestClient(t)
+			if _, err := api.join(client, common.PlayerState{Name: "twin"}, common.Capabilities, nil); err == nil {
+				atomic.AddInt64(&joined, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if joined != 1 || api.sessions.count() != 1 {
+		t.Fatalf("expected one session for a name, got %v joins and %v sessions", joined, api.sessions.count())
+	}
+}
+
+func TestStateKeepsJoinedName(t *testing.T) {
+	api := newAPI()
+	var ret bool
+	if err := api.UpdatePersonState(&common.PlayerState{Name: "anyone"}, &ret); err == nil {
+		t.Fatal("expected an error updating state before joining")
+	}
+
+	_, client := newTestClient(t)
+	s, err := api.join(client, common.PlayerState{Name: "alice"}, common.Capabilities, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.forSession(s).UpdatePersonState(&common.PlayerState{Name: "bob", Planet: 3}, &ret)
+	if s.State().Name != "alice" || s.State().Planet != 3 {
+		t.Fatalf("expected alice's state to change, got %+v", s.State())
+	}
+	if api.sessions.byName("bob") != nil {
+		t.Fatal("a client renamed itself by sending another name")
+	}
+}
+
+func TestKickSendsReasonThenDisconnects(t *testing.T) {
+	api := newAPI()
+	registerCommands()
+	c, client := newTestClient(t)
+	s, err := api.join(client, common.PlayerState{Name: "alice"}, common.Capabilities, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only operators may run commands from chat
+	var ret bool
+	text := "/kick alice"
+	api.forSession(s).Chat(&text, &ret)
+	if api.sessions.count() != 1 {
+		t.Fatal("a player who is not an operator kicked someone")
+	}
+
+	api.runCommand("kick alice too noisy")
+	waitFor(t, "kicked client to be dropped", func() bool {
+		return api.sessions.count() == 0
+	})
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.reason != "too noisy" {
+		t.Fatalf("expected the kick reason before disconnecting, got %q", c.reason)
+	}
+}
+
+func TestStatesOnlyReachPlayersInView(t *testing.T) {
+	api := newAPI()
+	playerRange = 10
+	defer func() { playerRange = 0 }()
+
+	join := func(name string, state common.PlayerState) (*testClient, *API) {
+		c, client := newTestClient(t)
+		state.Name = name
+		s, err := api.join(client, state, common.Capabilities, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c, api.forSession(s)
+	}
+	_, mover := join("mover", common.PlayerState{Planet: 1})
+	near, _ := join("near", common.PlayerState{Planet: 1, Position: [3]float32{5, 0, 0}})
+	far, _ := join("far", common.PlayerState{Planet: 1, Position: [3]float32{50, 0, 0}})
+	other, _ := join("other", common.PlayerState{Planet: 2})
+
+	var ret bool
+	mover.UpdatePersonState(&common.PlayerState{Planet: 1}, &ret)
+	waitFor(t, "near player to get the state", func() bool {
+		return atomic.LoadInt64(&near.states) == 1
+	})
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt64(&far.states) != 0 || atomic.LoadInt64(&other.states) != 0 {
+		t.Fatal("a player out of range or on another planet got the state")
+	}
+}
+
+func TestChatHistoryAndPrivateMessages(t *testing.T) {
+	api := newAPI()
+	chatMutex.Lock()
+	chatHistory = nil
+	chatMutex.Unlock()
+	join := func(name string) (*testClient, *API) {
+		c, client := newTestClient(t)
+		s, err := api.join(client, common.PlayerState{Name: name}, common.Capabilities, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c, api.forSession(s)
+	}
+
+	alice, aliceAPI := join("alice")
+	var ret bool
+	for _, text := range []string{"hi", "hi"} {
+		if err := aliceAPI.Chat(&text, &ret); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "repeated messages to arrive", func() bool {
+		return len(alice.chatTexts()) == 2
+	})
+
+	// Someone joining later hears what was said before
+	bob, _ := join("bob")
+	carol, _ := join("carol")
+	waitFor(t, "chat history on join", func() bool {
+		bob.mutex.Lock()
+		defer bob.mutex.Unlock()
+		return len(bob.history) == 2 && bob.history[0].From == "alice" && bob.history[0].Channel == common.ChatPublic
+	})
+
+	text := "/msg bob just for you"
+	aliceAPI.Chat(&text, &ret)
+	waitFor(t, "private message to reach both sides", func() bool {
+		b, a := bob.chatTexts(), alice.chatTexts()
+		return len(b) == 1 && b[0] == "just for you" && len(a) == 3
+	})
+	time.Sleep(10 * time.Millisecond)
+	if len(carol.chatTexts()) != 0 {
+		t.Fatalf("a private message reached someone else: %v", carol.chatTexts())
+	}
+
+	chatMutex.Lock()
+	defer chatMutex.Unlock()
+	if len(chatHistory) != 2 {
+		t.Fatalf("private messages must not be kept in the history, got %v messages", len(chatHistory))
+	}
+}
+
+func TestStatusQueryDoesNotJoin(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+	if err := ioutil.WriteFile("server.buildorb", []byte("motd=Welcome;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	universe = &common.Universe{PlanetMap: map[int]*common.Planet{0: {}, 1: {}}}
+	worldName = "testworld"
+
+	api := newAPI()
+	var joins int64
+	api.sessions.onEvent(func(e sessionEvent) {
+		if e.kind == sessionJoined {
+			atomic.AddInt64(&joins, 1)
+		}
+	})
+	_, client := newTestClient(t)
+	if _, err := api.join(client, common.PlayerState{Name: "alice"}, common.Capabilities, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	serverEnd, clientEnd := net.Pipe()
+	go api.handleConnection(serverEnd)
+	status, _, err := common.QueryStatus(clientEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.MOTD != "Welcome" || status.World != "testworld" || status.Planets != 2 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if len(status.Players) != 1 || status.Players[0] != "alice" {
+		t.Fatalf("expected alice to be listed, got %v", status.Players)
+	}
+	if api.sessions.count() != 1 || atomic.LoadInt64(&joins) != 1 {
+		t.Fatalf("the status query joined: %v sessions and %v joins", api.sessions.count(), joins)
+	}
+}
+
+func TestResumeKeepsPlaceItemsAndGameMode(t *testing.T) {
+	api := newAPI()
+	_, client := newTestClient(t)
+	s, err := api.join(client, common.PlayerState{Name: "dropped"}, common.Capabilities, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.setState(common.PlayerState{Planet: 1, Position: [3]float32{1, 2, 3}})
+	s.addItems(common.Slot{Material: common.Stone, Amount: 5})
+	s.setGameMode(common.Creative)
+
+	// The server may not notice the connection dropped before the player resumes
+	left := api.takeOver("dropped")
+	if left == nil {
+		t.Fatal("nothing was kept for the player to resume")
+	}
+	if api.sessions.count() != 0 {
+		t.Fatal("the old session is still registered")
+	}
+	_, client = newTestClient(t)
+	s, err = api.join(client, left.state, common.Capabilities, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.restore(left)
+	if state := s.State(); state.Name != "dropped" || state.Planet != 1 || state.Position != [3]float32{1, 2, 3} {
+		t.Fatalf("expected the player's place to be kept, got %+v", state)
+	}
+	if !s.takeItems(common.Slot{Material: common.Stone, Amount: 5}) {
+		t.Fatal("expected the player's items to be kept")
+	}
+	if s.gameMode != common.Creative {
+		t.Fatalf("expected the game mode to be kept, got %v", s.gameMode)
+	}
+
+	// Once taken, or after joining afresh, nothing is left to resume
+	if takeLeftSession("dropped") != nil {
+		t.Fatal("the kept session was resumed twice")
+	}
+}
+
+func TestBreakCellChecksMining(t *testing.T) {
+	tests := []struct {
+		name     string
+		creative bool
+		// mined is the cell the player started mining, if any, and elapsed how long ago
+		mined   *common.CellIndex
+		elapsed time.Duration
+		broken  bool
+	}{
+		{name: "no start", elapsed: time.Minute},
+		{name: "different cell", mined: &common.CellIndex{Lon: 2}, elapsed: time.Minute},
+		{name: "too soon", mined: &common.CellIndex{}},
+		{name: "long enough", mined: &common.CellIndex{}, elapsed: time.Minute, broken: true},
+		{name: "creative", creative: true, broken: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestWorld(t, "")
+			planet := common.NewPlanet(common.PlanetState{ID: 0, Radius: 32, AltCells: 32}, nil, nil)
+			universe = &common.Universe{PlanetMap: map[int]*common.Planet{0: planet}}
+			ind := common.PlanetCellIndex{CellIndex: common.CellIndex{Lon: 1, Lat: planet.LatCells / 2, Alt: 10}}
+			pos := planet.CellIndexToCartesian(ind.CellIndex)
+
+			api := newAPI()
+			_, client := newTestClient(t)
+			s, err := api.join(client, common.PlayerState{Name: "alice", Planet: 0, Position: pos}, common.Capabilities, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stopMining("alice")
+			if test.creative {
+				s.setGameMode(common.Creative)
+			}
+			if test.mined != nil {
+				mined := ind
+				mined.Lon += test.mined.Lon
+				var ret bool
+				if err := api.forSession(s).StartMining(&common.BreakCellArgs{PlanetCellIndex: mined}, &ret); err != nil {
+					t.Fatal(err)
+				}
+				miningMutex.Lock()
+				start := miningStarts["alice"]
+				start.time = start.time.Add(-test.elapsed)
+				miningStarts["alice"] = start
+				miningMutex.Unlock()
+			}
+
+			// The time the client claims to have mined for makes no difference
+			contents := []common.Slot{}
+			err = api.forSession(s).BreakCell(&common.BreakCellArgs{PlanetCellIndex: ind, Seconds: 60}, &contents)
+			if test.broken && err != nil {
+				t.Fatalf("expected the cell to be broken, got %v", err)
+			}
+			if !test.broken && (err == nil || err.Error() != "Cell was not mined long enough") {
+				t.Fatalf("expected the cell to be refused as not mined long enough, got %v", err)
+			}
+			if broken := planet.CellIndexToCell(ind.CellIndex).Material == common.Air; broken != test.broken {
+				t.Fatalf("expected the cell to be broken: %v, but it was: %v", test.broken, broken)
+			}
+		})
+	}
+}
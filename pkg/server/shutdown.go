@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// How long the server waits for kicked clients to hang up before closing their connections
+const shutdownDrainTimeout = 2 * time.Second
+
+var (
+	// stopping is set once the server starts shutting down
+	stopping int32
+
+	// saving is set once the countdown is over and the server starts saving the world to stop
+	saving int32
+
+	// skipCountdown is closed to shut down without waiting out the countdown
+	skipCountdown = make(chan bool)
+
+	// loopStopped is closed once the simulation loop has stopped for shutdown
+	loopStopped = make(chan bool)
+)
+
+// isStopping returns whether the server is shutting down
+func isStopping() bool {
+	return atomic.LoadInt32(&stopping) == 1
+}
+
+// isSaving returns whether the server is saving the world to stop, after which
+// the simulation loop no longer runs and the world no longer changes
+func isSaving() bool {
+	return atomic.LoadInt32(&saving) == 1
+}
+
+// shutdownCountdown is how long players are warned before the server stops,
+// set with "shutdowncountdown=<seconds>" in the server config
+func shutdownCountdown() time.Duration {
+	seconds, err := strconv.Atoi(getconfig("shutdowncountdown"))
+	if err != nil || seconds < 0 {
+		seconds = 10
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// handleSignals shuts the server down on SIGINT or SIGTERM. A second signal skips the countdown.
+func (api *API) handleSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	log.Printf("Received %v", sig)
+	go func() {
+		<-signals
+		log.Printf("Skipping shutdown countdown")
+		close(skipCountdown)
+	}()
+	api.shutdown("Server is shutting down", shutdownCountdown())
+}
+
+// shutdown stops accepting players, counts down for those playing, then saves the world,
+// disconnects everyone and exits. The world carries on through the countdown.
+// Only the first call does anything.
+func (api *API) shutdown(reason string, countdown time.Duration) {
+	if !atomic.CompareAndSwapInt32(&stopping, 0, 1) {
+		return
+	}
+	log.Printf("Shutting down in %v: %v", countdown, reason)
+	if listener != nil {
+		listener.Close()
+	}
+	for left := countdown; left > 0; {
+		api.announce(fmt.Sprintf("%v in %v", reason, left.Round(time.Second)))
+
+		// Announce every five seconds, then every second for the last five
+		step := time.Second
+		if left > 5*time.Second {
+			step = left - 5*time.Second
+			if step > 5*time.Second {
+				step = 5 * time.Second
+			}
+		}
+		select {
+		case <-time.After(step):
+			left -= step
+		case <-skipCountdown:
+			left = 0
+		}
+	}
+	os.Exit(api.stopWorld(reason))
+}
+
+// stopWorld stops the simulation, saves every player and changed chunk, disconnects everyone
+// with a reason and closes the database. It returns the status to exit with.
+func (api *API) stopWorld(reason string) (status int) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Error saving the world: %v", r)
+			status = 1
+		}
+		if err := db.Close(); err != nil {
+			log.Printf("Error closing the database: %v", err)
+			status = 1
+		}
+		log.Printf("Server stopped")
+	}()
+	atomic.StoreInt32(&saving, 1)
+	<-loopStopped
+	autosave(api, 0)
+
+	for _, s := range api.sessions.all() {
+		s.kick(reason)
+	}
+	deadline := time.Now().Add(shutdownDrainTimeout)
+	for api.sessions.count() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, s := range api.sessions.all() {
+		api.sessions.remove(s.id)
+	}
+
+	// Keep each planet locked so no late change is left unsaved
+	for _, planet := range universe.PlanetMap {
+		planet.DeltaMutex.Lock()
+		planet.SaveDirtyChunks()
+	}
+	return 0
+}
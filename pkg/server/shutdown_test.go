@@ -0,0 +1,49 @@
+package server
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+func TestWorldRunsUntilSaving(t *testing.T) {
+	useTestWorld(t, "")
+	resetWorld()
+	defer resetWorld()
+	universe = common.NewUniverse(db, "planet")
+	registerSystems()
+	api := newAPI()
+	_, client := newTestClient(t)
+	s, err := api.join(client, common.PlayerState{Name: "alice"}, common.Capabilities, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit := func() error {
+		_, _, err := api.forSession(s).checkEdit(common.PlanetCellIndex{})
+		return err
+	}
+	go api.runLoop()
+
+	// Shutdown has started, so the countdown is running
+	atomic.StoreInt32(&stopping, 1)
+	start := universe.Time()
+	waitFor(t, "the clock to advance during the countdown", func() bool {
+		return universe.Time() > start
+	})
+	if err := edit(); err != nil && err.Error() == "Server is shutting down" {
+		t.Fatal("an edit was refused during the countdown")
+	}
+
+	if status := api.stopWorld("Stopping"); status != 0 {
+		t.Fatalf("stopping the world gave status %v", status)
+	}
+	select {
+	case <-loopStopped:
+	default:
+		t.Fatal("the loop kept running after the world was saved")
+	}
+	if err := edit(); err == nil || err.Error() != "Server is shutting down" {
+		t.Fatalf("expected edits to be refused once saving, got %v", err)
+	}
+}
@@ -6,17 +6,20 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
-	"net/rpc"
 	"os"
 	"strings"
+	"sync/atomic"
+	"time"
 
-	"github.com/hashicorp/yamux"
 	"github.com/jeffbaumes/buildorb/pkg/common"
 	_ "github.com/mattn/go-sqlite3" // Needed to use sqlite
 )
 
 var (
-	universe *common.Universe
+	universe  *common.Universe
+	db        *sql.DB
+	listener  net.Listener
+	worldName string
 )
 
 type server struct {
@@ -46,12 +49,13 @@ func readfile() string {
 	return string(b)
 }
 
-func getsystem() (f5 string) {
+// getconfig returns the value of a key in the server config file, which holds key=value pairs separated by semicolons
+func getconfig(key string) (f5 string) {
 	f2 := readfile()
 	f := strings.Split(f2, ";")
 	for _, f3 := range f {
-		f4 := strings.Split(f3, "=")
-		if f4[0] == "system" {
+		f4 := strings.Split(strings.TrimSpace(f3), "=")
+		if f4[0] == key && len(f4) > 1 {
 			f5 = f4[1]
 		}
 	}
@@ -63,11 +67,52 @@ func Start(name string, seed, port int) {
 	if port == 0 {
 		port = 5555
 	}
+	api := openWorld(name, seed)
+	go api.runConsole(os.Stdin, os.Stdout)
+	api.listenConsole()
+
+	var e error
+	listener, e = net.Listen("tcp", fmt.Sprintf(":%v", port))
+	if e != nil {
+		log.Fatal("listen error:", e)
+	}
+	go api.handleSignals()
+	api.serveMetrics()
+	log.Printf("Server listening on port %v...\n", port)
+	api.accept(listener)
+
+	// Shutting down, which exits once the world is saved
+	select {}
+}
+
+// openWorld opens a world's database and starts simulating it
+func openWorld(name string, seed int) *API {
+	resetWorld()
+	worldName = name
+	startTime = time.Now()
 	_ = os.Mkdir("worlds/", os.ModePerm)
 	dbName := "worlds/" + name + ".db"
 
-	db, err := sql.Open("sqlite3", dbName)
+	var err error
+	db, err = sql.Open("sqlite3", dbName)
 	checkErr(err)
+	createTables()
+
+	common.ChunkLoaded = observeChunkLoad
+	common.DatabaseWrite = observeChunkWrite
+	universe = common.NewUniverse(db, getconfig("system"))
+	loadClock()
+	loadPlayerRange()
+
+	api := newAPI()
+	registerSystems()
+	registerCommands()
+	go api.runLoop()
+	return api
+}
+
+// createTables creates any of the world's tables missing from the database
+func createTables() {
 	stmt, err := db.Prepare("CREATE TABLE IF NOT EXISTS chunk (planet INT, lon INT, lat INT, alt INT, data BLOB, PRIMARY KEY (planet, lat, lon, alt))")
 	checkErr(err)
 	_, err = stmt.Exec()
@@ -80,58 +125,84 @@ func Start(name string, seed, port int) {
 	checkErr(err)
 	_, err = stmt.Exec()
 	checkErr(err)
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS universe (id INT PRIMARY KEY, clock REAL)")
+	checkErr(err)
+	_, err = stmt.Exec()
+	checkErr(err)
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS account (name TEXT PRIMARY KEY, salt BLOB, hash BLOB)")
+	checkErr(err)
+	_, err = stmt.Exec()
+	checkErr(err)
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS ban (name TEXT PRIMARY KEY, reason TEXT)")
+	checkErr(err)
+	_, err = stmt.Exec()
+	checkErr(err)
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS blockentity (planet INT, lon INT, lat INT, alt INT, data BLOB, PRIMARY KEY (planet, lon, lat, alt))")
+	checkErr(err)
+	_, err = stmt.Exec()
+	checkErr(err)
+}
 
-	universe = common.NewUniverse(db, getsystem())
+// resetWorld forgets the state of any world opened earlier in this process
+func resetWorld() {
+	atomic.StoreInt32(&stopping, 0)
+	atomic.StoreInt32(&saving, 0)
+	skipCountdown = make(chan bool)
+	loopStopped = make(chan bool)
+	listener = nil
+	localOwner = ""
+	systems = nil
+
+	// Players of the last world may still be disconnecting, so reset under the same locks they use
+	blockEntitiesMutex.Lock()
+	blockEntities = make(map[common.PlanetCellIndex]*blockEntity)
+	blockEntitiesMutex.Unlock()
+	blockUpdatesMutex.Lock()
+	blockUpdates = nil
+	blockUpdatesMutex.Unlock()
+	miningMutex.Lock()
+	miningStarts = make(map[string]miningStart)
+	miningMutex.Unlock()
+	chatMutex.Lock()
+	chatHistory = nil
+	chatMutex.Unlock()
+	resumeMutex.Lock()
+	leftSessions = make(map[string]*leftSession)
+	resumeMutex.Unlock()
+}
 
-	api := new(API)
-	listener, e := net.Listen("tcp", fmt.Sprintf(":%v", port))
-	if e != nil {
-		log.Fatal("listen error:", e)
-	}
-	log.Printf("Server listening on port %v...\n", port)
+// accept serves the connections made to a listener until it is closed for shutdown,
+// announcing the server on the local network meanwhile if that is turned on. Temporary
+// errors are retried after a growing delay, as net/http does.
+func (api *API) accept(l net.Listener) {
+	stop := make(chan bool)
+	defer close(stop)
+	go api.announceLAN(l.Addr().(*net.TCPAddr).Port, stop)
+	var delay time.Duration
 	for {
-		conn, e := listener.Accept()
-		if e != nil {
-			panic(e)
-		}
-
-		// Set up server side of yamux
-		mux, e := yamux.Server(conn, nil)
+		conn, e := l.Accept()
 		if e != nil {
-			panic(e)
+			if isStopping() {
+				return
+			}
+			if ne, ok := e.(net.Error); !ok || !ne.Temporary() {
+				log.Println("accept error, no longer accepting connections:", e)
+				return
+			}
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			log.Printf("accept error: %v; retrying in %v", e, delay)
+			time.Sleep(delay)
+			continue
 		}
-		muxConn, e := mux.Accept()
-		if e != nil {
-			panic(e)
-		}
-		srpc := rpc.NewServer()
-		srpc.Register(api)
-		go srpc.ServeConn(muxConn)
-
-		// Set up stream back to client
-		stream, e := mux.Open()
-		if e != nil {
-			panic(e)
-		}
-		crpc := rpc.NewClient(stream)
-
-		// Ask client for player name
-		var state common.PlayerState
-		e = crpc.Call("API.GetPersonState", 0, &state)
-		if e != nil {
-			log.Fatal("GetPersonState error:", e)
-		}
-		p := connectedPerson{state: state, rpc: crpc}
-		log.Println(p.state.Name)
-		api.connectedPeople = append(api.connectedPeople, &p)
+		delay = 0
+		go api.handleConnection(conn)
 	}
 }
 
-type connectedPerson struct {
-	rpc   *rpc.Client
-	state common.PlayerState
-}
-
 func checkErr(err error) {
 	if err != nil {
 		panic(err)
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// startTime is when the world was opened
+var startTime time.Time
+
+// status returns what the server tells anyone who asks, with the message of the day
+// set with "motd=<text>" in the server config
+func (api *API) status() *common.Status {
+	players := []string{}
+	for _, s := range api.sessions.all() {
+		players = append(players, s.Name())
+	}
+	sort.Strings(players)
+	return &common.Status{
+		MOTD:            getconfig("motd"),
+		Name:            serverName(),
+		World:           worldName,
+		ProtocolVersion: common.ProtocolVersion,
+		Build:           common.Build,
+		Players:         players,
+		Planets:         len(universe.PlanetMap),
+		Uptime:          time.Since(startTime),
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// subscribe records that the client holds a chunk and should be sent its changes
+func (s *session) subscribe(ind common.PlanetChunkIndex) {
+	s.mutex.Lock()
+	s.chunks[ind] = true
+	s.mutex.Unlock()
+}
+
+// unsubscribe stops sending the client changes to a chunk
+func (s *session) unsubscribe(ind common.PlanetChunkIndex) {
+	s.mutex.Lock()
+	delete(s.chunks, ind)
+	s.mutex.Unlock()
+}
+
+// subscribed returns whether the client is sent changes to a chunk
+func (s *session) subscribed(ind common.PlanetChunkIndex) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.chunks[ind]
+}
+
+// SubscribeChunks returns a batch of chunks, packed and compressed along with the number
+// of each chunk's last delta, and sends the caller the deltas of their later changes.
+// Chunks the caller already has as of their last delta are left out.
+func (api *API) SubscribeChunks(args *common.ChunkBatchArgs, encoded *[]common.EncodedChunk) error {
+	if api.session == nil {
+		return errors.New("Not joined")
+	}
+	planet := universe.PlanetMap[args.Planet]
+	if planet == nil {
+		return errors.New("Unknown planet ID")
+	}
+	if len(args.Chunks) > common.MaxChunkBatch {
+		return errors.New("Too many chunks in one request")
+	}
+	if args.Seqs != nil && len(args.Seqs) != len(args.Chunks) {
+		return errors.New("Chunk numbers do not match the chunks")
+	}
+
+	// Load the chunks first, since chunks that were never visited are generated
+	chunks := make([]*common.Chunk, len(args.Chunks))
+	for i, ind := range args.Chunks {
+		chunks[i] = planet.GetChunk(ind, false)
+		if chunks[i] == nil {
+			return errors.New("Chunk is outside the world")
+		}
+	}
+
+	// Pack each chunk as of its last delta, compressing after letting changes continue
+	packed := make([][]byte, len(chunks))
+	seqs := make([]uint64, len(chunks))
+	planet.DeltaMutex.Lock()
+	for i, chunk := range chunks {
+		if args.Seqs == nil || args.Seqs[i] != chunk.Seq {
+			packed[i] = chunk.Pack()
+		}
+		seqs[i] = chunk.Seq
+		api.session.subscribe(common.PlanetChunkIndex{Planet: args.Planet, ChunkIndex: args.Chunks[i]})
+	}
+	planet.DeltaMutex.Unlock()
+	for i, ind := range args.Chunks {
+		if packed[i] != nil {
+			*encoded = append(*encoded, common.EncodedChunk{ChunkIndex: ind, Seq: seqs[i], Data: common.CompressChunk(packed[i])})
+		}
+	}
+	return nil
+}
+
+// UnsubscribeChunk stops sending the caller changes to a chunk
+func (api *API) UnsubscribeChunk(args *common.PlanetChunkIndex, ret *bool) error {
+	if api.session == nil {
+		return errors.New("Not joined")
+	}
+	api.session.unsubscribe(*args)
+	*ret = true
+	return nil
+}
+
+// changeCells applies changes to the cells of a planet and sends each changed chunk's
+// next delta to the clients subscribed to it, returning the changes that modified a cell
+func (api *API) changeCells(planet *common.Planet, changes []common.CellChange) []common.CellChange {
+	planet.DeltaMutex.Lock()
+	defer planet.DeltaMutex.Unlock()
+	applied := planet.SetCellMaterials(changes)
+	deltas := make(map[common.ChunkIndex]*common.ChunkDelta)
+	for _, change := range applied {
+		ind := planet.CellIndexToChunkIndex(change.Index)
+		if deltas[ind] == nil {
+			deltas[ind] = &common.ChunkDelta{PlanetChunkIndex: common.PlanetChunkIndex{Planet: planet.ID, ChunkIndex: ind}}
+		}
+		deltas[ind].Changes = append(deltas[ind].Changes, change)
+	}
+	sessions := api.sessions.all()
+	for ind, delta := range deltas {
+		chunk := planet.GetChunk(ind, false)
+		chunk.Seq++
+		delta.Seq = chunk.Seq
+		for _, s := range sessions {
+			if s.subscribed(delta.PlanetChunkIndex) {
+				s.out.send("API.ChunkDelta", delta)
+			}
+		}
+	}
+	return applied
+}
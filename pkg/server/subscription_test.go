@@ -0,0 +1,179 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+func TestChunkDeltasReachSubscribersInOrder(t *testing.T) {
+	planet := common.NewPlanet(common.PlanetState{ID: 0, Radius: 32, AltCells: 32}, nil, nil)
+	universe = &common.Universe{PlanetMap: map[int]*common.Planet{0: planet}}
+	api := newAPI()
+
+	join := func(name string) (*testClient, *API) {
+		c, client := newTestClient(t)
+		s, err := api.join(client, common.PlayerState{Name: name}, common.Capabilities, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c, api.forSession(s)
+	}
+	subscriber, subscriberAPI := join("subscriber")
+	other, _ := join("other")
+
+	cell := common.CellIndex{Lon: 1, Lat: 1, Alt: 1}
+	chunkInd := common.PlanetChunkIndex{Planet: 0, ChunkIndex: planet.CellIndexToChunkIndex(cell)}
+	args := common.ChunkBatchArgs{Planet: 0, Chunks: []common.ChunkIndex{chunkInd.ChunkIndex}}
+	var encoded []common.EncodedChunk
+	if err := subscriberAPI.SubscribeChunks(&args, &encoded); err != nil {
+		t.Fatal(err)
+	}
+	if encoded[0].Seq != 0 {
+		t.Fatalf("expected a new chunk to start at delta 0, got %v", encoded[0].Seq)
+	}
+
+	// Changes that leave the cell as it is send no delta
+	first, second := common.Stone, common.Dirt
+	if planet.CellIndexToCell(cell).Material == first {
+		first, second = second, first
+	}
+	api.changeCells(planet, []common.CellChange{{Index: cell, Material: first}})
+	api.changeCells(planet, []common.CellChange{{Index: cell, Material: first}})
+	api.changeCells(planet, []common.CellChange{{Index: cell, Material: second}})
+	waitFor(t, "deltas to arrive", func() bool {
+		subscriber.mutex.Lock()
+		defer subscriber.mutex.Unlock()
+		return len(subscriber.deltas) == 2
+	})
+	subscriber.mutex.Lock()
+	for i, delta := range subscriber.deltas {
+		if delta.Seq != uint64(i+1) || delta.PlanetChunkIndex != chunkInd {
+			t.Fatalf("delta %v has number %v for chunk %v", i, delta.Seq, delta.PlanetChunkIndex)
+		}
+	}
+	subscriber.mutex.Unlock()
+
+	encoded = nil
+	if err := subscriberAPI.SubscribeChunks(&args, &encoded); err != nil {
+		t.Fatal(err)
+	}
+	chunk, err := planet.DecodeChunk(encoded[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunk.Seq != 2 {
+		t.Fatalf("expected the chunk to be at delta 2, got %v", chunk.Seq)
+	}
+	if chunk.Cells[1][1][1].Material != second {
+		t.Fatalf("expected the sent chunk to include the changes, got material %v", chunk.Cells[1][1][1].Material)
+	}
+	other.mutex.Lock()
+	defer other.mutex.Unlock()
+	if len(other.deltas) != 0 {
+		t.Fatal("a client that did not subscribe got deltas")
+	}
+
+	var ret bool
+	subscriberAPI.UnsubscribeChunk(&chunkInd, &ret)
+	api.changeCells(planet, []common.CellChange{{Index: cell, Material: common.Air}})
+	if subscriberAPI.session.subscribed(chunkInd) {
+		t.Fatal("still subscribed after unsubscribing")
+	}
+}
+
+func TestChunkBatchRoundTrip(t *testing.T) {
+	planet := common.NewPlanet(common.PlanetState{ID: 0, Radius: 32, AltCells: 32}, nil, nil)
+	universe = &common.Universe{PlanetMap: map[int]*common.Planet{0: planet}}
+	api := newAPI()
+	_, client := newTestClient(t)
+	s, err := api.join(client, common.PlayerState{Name: "loader"}, common.Capabilities, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := common.ChunkBatchArgs{Planet: 0}
+	for lat := 0; lat < planet.LatCells/common.ChunkSize; lat++ {
+		for alt := 0; alt < planet.AltCells/common.ChunkSize; alt++ {
+			args.Chunks = append(args.Chunks, common.ChunkIndex{Lon: 0, Lat: lat, Alt: alt})
+		}
+	}
+	var encoded []common.EncodedChunk
+	if err := api.forSession(s).SubscribeChunks(&args, &encoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(encoded) != len(args.Chunks) {
+		t.Fatalf("asked for %v chunks, got %v", len(args.Chunks), len(encoded))
+	}
+	for _, e := range encoded {
+		chunk, err := planet.DecodeChunk(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		original := planet.GetChunk(e.ChunkIndex, false)
+		for lon := range original.Cells {
+			for lat := range original.Cells[lon] {
+				for alt, cell := range original.Cells[lon][lat] {
+					if *chunk.Cells[lon][lat][alt] != *cell {
+						t.Fatalf("chunk %v cell %v,%v,%v changed in transfer", e.ChunkIndex, lon, lat, alt)
+					}
+				}
+			}
+		}
+	}
+
+	args.Chunks = make([]common.ChunkIndex, common.MaxChunkBatch+1)
+	if err := api.forSession(s).SubscribeChunks(&args, &encoded); err == nil {
+		t.Fatal("expected an error asking for too many chunks")
+	}
+}
+
+func TestResubscribeSendsOnlyChangedChunks(t *testing.T) {
+	planet := common.NewPlanet(common.PlanetState{ID: 0, Radius: 32, AltCells: 32}, nil, nil)
+	universe = &common.Universe{PlanetMap: map[int]*common.Planet{0: planet}}
+	api := newAPI()
+	_, client := newTestClient(t)
+	s, err := api.join(client, common.PlayerState{Name: "returning"}, common.Capabilities, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cell := common.CellIndex{Lon: 1, Lat: 1, Alt: 1}
+	changed := planet.CellIndexToChunkIndex(cell)
+	unchanged := common.ChunkIndex{Lon: changed.Lon, Lat: changed.Lat + 1, Alt: changed.Alt}
+	args := common.ChunkBatchArgs{Planet: 0, Chunks: []common.ChunkIndex{changed, unchanged}}
+	var encoded []common.EncodedChunk
+	if err := api.forSession(s).SubscribeChunks(&args, &encoded); err != nil {
+		t.Fatal(err)
+	}
+	material := common.Stone
+	if planet.CellIndexToCell(cell).Material == material {
+		material = common.Dirt
+	}
+	api.changeCells(planet, []common.CellChange{{Index: cell, Material: material}})
+
+	// The client comes back with the chunks as it last had them
+	_, client = newTestClient(t)
+	s, err = api.join(client, common.PlayerState{Name: "returning again"}, common.Capabilities, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args.Seqs = []uint64{encoded[0].Seq, encoded[1].Seq}
+	var resent []common.EncodedChunk
+	if err := api.forSession(s).SubscribeChunks(&args, &resent); err != nil {
+		t.Fatal(err)
+	}
+	if len(resent) != 1 || resent[0].ChunkIndex != changed || resent[0].Seq != encoded[0].Seq+1 {
+		t.Fatalf("expected only the changed chunk to be sent again, got %+v", resent)
+	}
+	for _, ind := range args.Chunks {
+		if !s.subscribed(common.PlanetChunkIndex{Planet: 0, ChunkIndex: ind}) {
+			t.Fatalf("not subscribed to %v after resubscribing", ind)
+		}
+	}
+
+	args.Seqs = args.Seqs[:1]
+	if err := api.forSession(s).SubscribeChunks(&args, &resent); err == nil {
+		t.Fatal("expected an error when the numbers do not match the chunks")
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// How often the autosave system runs, and how long a player can go without
+// sending their state before they are disconnected
+const (
+	autosaveInterval = 30 * time.Second
+	playerTimeout    = 30 * time.Second
+)
+
+// blockUpdate is a change to the world scheduled for a later universe time
+type blockUpdate struct {
+	due float64
+	run func(api *API)
+}
+
+var (
+	blockUpdatesMutex = &sync.Mutex{}
+	blockUpdates      []*blockUpdate
+)
+
+// registerSystems registers the systems run by the simulation loop.
+// There is no movement system: planet orbits and rotations are worked out from the universe clock,
+// which the clock system advances and clients follow, and the server has no other moving entities yet.
+func registerSystems() {
+	registerSystem("clock", 1, advanceClock)
+	registerSystem("blockupdates", 1, runBlockUpdates)
+	registerSystem("timeouts", tickRate, timeOutPlayers)
+	registerSystem("autosave", int(autosaveInterval/tickDuration), autosave)
+}
+
+func advanceClock(api *API, seconds float64) {
+	universe.AdvanceTime(seconds)
+}
+
+// scheduleBlockUpdate runs a world change after a delay in seconds of universe time
+func scheduleBlockUpdate(delay float64, run func(api *API)) {
+	blockUpdatesMutex.Lock()
+	blockUpdates = append(blockUpdates, &blockUpdate{due: universe.Time() + delay, run: run})
+	blockUpdatesMutex.Unlock()
+}
+
+func runBlockUpdates(api *API, seconds float64) {
+	now := universe.Time()
+	var due, waiting []*blockUpdate
+	blockUpdatesMutex.Lock()
+	for _, u := range blockUpdates {
+		if u.due <= now {
+			due = append(due, u)
+		} else {
+			waiting = append(waiting, u)
+		}
+	}
+	blockUpdates = waiting
+	blockUpdatesMutex.Unlock()
+	for _, u := range due {
+		u.run(api)
+	}
+}
+
+// timeOutPlayers disconnects anyone who has not sent their state recently
+func timeOutPlayers(api *API, seconds float64) {
+	for _, s := range api.sessions.all() {
+		if time.Since(s.LastSeen()) > playerTimeout {
+			log.Printf("%v timed out", s.State().Name)
+			api.sessions.remove(s.id)
+		}
+	}
+}
+
+// autosave saves the universe clock, everyone connected and the chunks that changed
+func autosave(api *API, seconds float64) {
+	for _, planet := range universe.PlanetMap {
+		planet.DeltaMutex.Lock()
+		planet.SaveDirtyChunks()
+		planet.DeltaMutex.Unlock()
+	}
+	start := time.Now()
+	_, err := db.Exec("INSERT OR REPLACE INTO universe VALUES (0, ?)", universe.Time())
+	checkErr(err)
+	observeWrite("universe", start)
+	for _, s := range api.sessions.all() {
+		savePlayer(s)
+	}
+}
+
+// loadClock sets the universe clock to where it was when the universe was last saved
+func loadClock() {
+	rows, err := db.Query("SELECT clock FROM universe WHERE id = 0")
+	checkErr(err)
+	defer rows.Close()
+	if rows.Next() {
+		var clock float64
+		checkErr(rows.Scan(&clock))
+		universe.SetTime(clock)
+	}
+}
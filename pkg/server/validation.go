@@ -0,0 +1,176 @@
+package server
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// Limits on what a player may change in the world
+const (
+	// maxReach is how far from a player's eyes the client lets them edit cells or hit people
+	maxReach = 5
+
+	// reachSlack allows for the size of a cell and movement since the player's last state update
+	reachSlack = 2
+
+	// editRate is how many edits per second a player may keep up, with bursts of up to editBurst
+	editRate  = 10
+	editBurst = 20
+
+	// meleeDamage is the damage of one hit, whatever the client claims
+	meleeDamage = 1
+)
+
+// editMutex makes edits to the world one at a time, so the cells an edit checks cannot change
+// before it makes its change. It is taken before blockEntitiesMutex and any planet's DeltaMutex.
+var editMutex = &sync.Mutex{}
+
+// allowEdit takes one edit from the session's rate limit, returning false if it has none left
+func (s *session) allowEdit() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	if s.editTime.IsZero() {
+		s.editTokens = editBurst
+	} else {
+		s.editTokens += now.Sub(s.editTime).Seconds() * editRate
+		if s.editTokens > editBurst {
+			s.editTokens = editBurst
+		}
+	}
+	s.editTime = now
+	if s.editTokens < 1 {
+		return false
+	}
+	s.editTokens--
+	return true
+}
+
+// addItems records items the player collected and sends the client their inventory
+func (s *session) addItems(slots ...common.Slot) {
+	s.mutex.Lock()
+	added := false
+	for _, slot := range slots {
+		if slot.Amount > 0 && slot.Material != common.Air {
+			s.items[slot.Material] += slot.Amount
+			added = true
+		}
+	}
+	s.mutex.Unlock()
+	if added {
+		s.sendInventory()
+	}
+}
+
+// takeItems removes items the player used, returning false if they do not have them
+func (s *session) takeItems(slot common.Slot) bool {
+	if slot.Amount <= 0 || slot.Material == common.Air {
+		return true
+	}
+	s.mutex.Lock()
+	if s.items[slot.Material] < slot.Amount {
+		s.mutex.Unlock()
+		return false
+	}
+	s.items[slot.Material] -= slot.Amount
+	s.mutex.Unlock()
+	s.sendInventory()
+	return true
+}
+
+// inventory returns the items the player has, one slot per material
+func (s *session) inventory() []common.Slot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	slots := []common.Slot{}
+	for material, amount := range s.items {
+		if amount > 0 {
+			slots = append(slots, common.Slot{Material: material, Amount: amount})
+		}
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Material < slots[j].Material })
+	return slots
+}
+
+// sendInventory sends the client the items the server counts the player as having,
+// which the client arranges in its own slots
+func (s *session) sendInventory() {
+	slots := s.inventory()
+	s.out.send("API.SetInventory", &slots)
+}
+
+// checkEdit returns the planet and cell the caller wants to change, or an error if
+// they are not joined, are editing too fast, or the cell is out of the world or out of reach.
+// Callers that go on to change the world must hold editMutex until they have.
+func (api *API) checkEdit(ind common.PlanetCellIndex) (*common.Planet, *common.Cell, error) {
+	if api.session == nil {
+		return nil, nil, errors.New("Not joined")
+	}
+	if isSaving() {
+		return nil, nil, errors.New("Server is shutting down")
+	}
+	if !api.session.allowEdit() {
+		return nil, nil, errors.New("Too many changes, slow down")
+	}
+	planet := universe.PlanetMap[ind.Planet]
+	if planet == nil {
+		return nil, nil, errors.New("Unknown planet ID")
+	}
+	if !planet.ValidCellIndex(ind.CellIndex) {
+		return nil, nil, errors.New("Cell is outside the world")
+	}
+	cell := planet.CellIndexToCell(ind.CellIndex)
+	if cell == nil {
+		return nil, nil, errors.New("Cell is outside the world")
+	}
+	if !api.inReach(ind.Planet, planet.CellIndexToCartesian(ind.CellIndex)) {
+		return nil, nil, errors.New("Cell is out of reach")
+	}
+	return planet, cell, nil
+}
+
+// inReach returns whether a position on a planet is within reach of the caller's last known position
+func (api *API) inReach(planetID int, pos mgl32.Vec3) bool {
+	state := api.session.State()
+	if state.Planet != planetID {
+		return false
+	}
+	return state.Position.Sub(pos).Len() <= maxReach+reachSlack
+}
+
+// defaultGameMode returns the game mode of players joining for the first time,
+// survival unless set with "gamemode=creative" in the server config
+func defaultGameMode() int {
+	if getconfig("gamemode") == "creative" {
+		return common.Creative
+	}
+	return common.Survival
+}
+
+// setGameMode records the game mode an admin set for the player
+func (s *session) setGameMode(mode int) {
+	s.mutex.Lock()
+	s.gameMode = mode
+	s.mutex.Unlock()
+}
+
+// creative returns whether the player is in creative mode, placing and breaking
+// cells without collecting or using up items
+func (s *session) creative() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.gameMode == common.Creative
+}
+
+// checkMaterial returns an error if a material does not exist
+func checkMaterial(material int) error {
+	if material < 0 || material >= len(common.Materials) {
+		return errors.New("Unknown material")
+	}
+	return nil
+}